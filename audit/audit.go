@@ -0,0 +1,222 @@
+// Package audit keeps an append-only, hash-chained log of changes to votes and other records.
+//
+// Every entry stores the hash of the entry before it, and a single head row holds the sequence
+// number and hash of the newest entry. Editing, removing or reordering any entry, or dropping
+// entries from the end, breaks the chain and is reported by Verify.
+//
+// The hashes are HMAC-SHA256 keyed with a secret set by SetKey that is never stored in the
+// database, so someone able to write to the audit tables cannot rebuild a valid chain after
+// changing them. Changing the key breaks verification of every entry written under the old one.
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"fedco/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Sources say which part of the system made a change.
+const (
+	SourceVoter          = "voter"
+	SourceCallback       = "callback"
+	SourceReconciliation = "reconciliation"
+	SourceAdmin          = "admin"
+	SourceSettlement     = "settlement"
+)
+
+// Actions recorded on entries.
+const (
+	ActionCreated       = "created"
+	ActionStatusChanged = "status_changed"
+	ActionAmountChanged = "amount_changed"
+	ActionDeleted       = "deleted"
+)
+
+var key []byte
+
+// SetKey sets the secret the chain is keyed with. Call it once at startup, before anything is
+// recorded or verified. Without a key the hashes are still computed, but anyone can forge them.
+func SetKey(secret string) { key = []byte(secret) }
+
+// Entry is one change. OldValue and NewValue hold JSON snapshots of the fields that changed.
+type Entry struct {
+	ID         uint      `gorm:"primaryKey;autoIncrement:false" json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Entity     string    `gorm:"type:varchar(32);index:idx_audit_entity" json:"entity"`
+	EntityID   uint      `gorm:"index:idx_audit_entity" json:"entity_id"`
+	ExternalID string    `gorm:"type:varchar(100);index" json:"external_id,omitempty"`
+	Action     string    `gorm:"type:varchar(32)" json:"action"`
+	Source     string    `gorm:"type:varchar(32)" json:"source"`
+	Actor      string    `json:"actor"`
+	OldValue   string    `gorm:"type:text" json:"old_value"`
+	NewValue   string    `gorm:"type:text" json:"new_value"`
+	PrevHash   string    `gorm:"type:char(64)" json:"prev_hash"`
+	Hash       string    `gorm:"type:char(64);uniqueIndex" json:"hash"`
+}
+
+func (Entry) TableName() string { return "audit_entries" }
+
+// head is the single row pointing at the newest entry. Locking it serializes appends.
+type head struct {
+	ID   uint `gorm:"primaryKey"`
+	Seq  uint
+	Hash string `gorm:"type:char(64)"`
+}
+
+func (head) TableName() string { return "audit_head" }
+
+// Report is the outcome of Verify.
+type Report struct {
+	Entries  int    `json:"entries"`
+	Head     string `json:"head"`
+	Valid    bool   `json:"valid"`
+	BrokenAt uint   `json:"broken_at,omitempty"`
+	Problem  string `json:"problem,omitempty"`
+}
+
+// Migrate creates the audit tables and the head row.
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&Entry{}, &head{}); err != nil {
+		return err
+	}
+	return db.FirstOrCreate(&head{}, head{ID: 1}).Error
+}
+
+// Values encodes a snapshot for OldValue or NewValue.
+func Values(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%q", fmt.Sprint(v))
+	}
+	return string(data)
+}
+
+// Vote builds an entry about a vote.
+func Vote(vote *models.Vote, action, source, actor string, oldValue, newValue interface{}) *Entry {
+	return &Entry{
+		Entity:     "vote",
+		EntityID:   vote.ID,
+		ExternalID: vote.ExternalID,
+		Action:     action,
+		Source:     source,
+		Actor:      actor,
+		OldValue:   Values(oldValue),
+		NewValue:   Values(newValue),
+	}
+}
+
+// Record appends e to the chain. Call it inside the transaction that makes the change so the
+// entry commits or rolls back with it.
+func Record(tx *gorm.DB, e *Entry) error {
+	var h head
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&h, 1).Error; err != nil {
+		return fmt.Errorf("failed to lock audit chain: %w", err)
+	}
+
+	e.ID = h.Seq + 1
+	e.PrevHash = h.Hash
+	// The database keeps milliseconds, so hash exactly what will be read back.
+	e.CreatedAt = time.Now().Truncate(time.Millisecond)
+	e.Hash = e.computeHash()
+
+	if err := tx.Create(e).Error; err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	if err := tx.Model(&h).Updates(map[string]interface{}{"seq": e.ID, "hash": e.Hash}).Error; err != nil {
+		return fmt.Errorf("failed to advance audit chain: %w", err)
+	}
+	return nil
+}
+
+func (e *Entry) computeHash() string {
+	fields, _ := json.Marshal([]interface{}{
+		e.ID, e.CreatedAt.UnixMilli(), e.Entity, e.EntityID, e.ExternalID,
+		e.Action, e.Source, e.Actor, e.OldValue, e.NewValue, e.PrevHash,
+	})
+	mac := hmac.New(sha256.New, key)
+	mac.Write(fields)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify walks the whole chain and reports the first entry that does not follow from the one
+// before it, or a head that does not point at the last entry.
+func Verify(db *gorm.DB) (*Report, error) {
+	var h head
+	if err := db.First(&h, 1).Error; err != nil {
+		return nil, fmt.Errorf("failed to read audit chain head: %w", err)
+	}
+
+	var c chain
+	var batch []Entry
+	err := db.Order("id ASC").FindInBatches(&batch, 1000, func(tx *gorm.DB, _ int) error {
+		for _, e := range batch {
+			if !c.add(e) {
+				return errStop
+			}
+		}
+		return nil
+	}).Error
+	if err != nil && !errors.Is(err, errStop) {
+		return nil, err
+	}
+	return c.report(h), nil
+}
+
+// chain checks entries one at a time, in ID order, for Verify.
+type chain struct {
+	entries  int
+	lastID   uint
+	prevHash string
+	brokenAt uint
+	problem  string
+}
+
+// add checks e against the entries before it and reports whether the chain still holds.
+func (c *chain) add(e Entry) bool {
+	switch {
+	case e.ID != c.lastID+1:
+		c.problem = fmt.Sprintf("expected entry %d, found %d: entries are missing", c.lastID+1, e.ID)
+	case e.PrevHash != c.prevHash:
+		c.problem = "previous hash does not match the entry before it"
+	case !hmac.Equal([]byte(e.computeHash()), []byte(e.Hash)):
+		c.problem = "entry contents do not match its hash"
+	default:
+		c.entries++
+		c.lastID = e.ID
+		c.prevHash = e.Hash
+		return true
+	}
+	c.brokenAt = e.ID
+	return false
+}
+
+// report finishes the check against the head row once every entry has been added.
+func (c *chain) report(h head) *Report {
+	report := &Report{Entries: c.entries, Head: h.Hash, Valid: c.problem == ""}
+	if !report.Valid {
+		report.BrokenAt = c.brokenAt
+		report.Problem = c.problem
+		return report
+	}
+
+	if h.Seq != c.lastID || h.Hash != c.prevHash {
+		report.Valid = false
+		report.BrokenAt = c.lastID
+		report.Problem = fmt.Sprintf("head points at entry %d but the chain ends at %d", h.Seq, c.lastID)
+	}
+	return report
+}
+
+var errStop = errors.New("audit chain broken")
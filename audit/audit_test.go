@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"testing"
+	"time"
+)
+
+// buildChain links entries the way Record does and returns them with the matching head.
+func buildChain(n int) ([]Entry, head) {
+	var entries []Entry
+	var h head
+	for i := 0; i < n; i++ {
+		e := Entry{
+			ID:        h.Seq + 1,
+			CreatedAt: time.Date(2026, 3, 1, 12, 0, i, 0, time.UTC),
+			Entity:    "vote",
+			EntityID:  uint(i + 1),
+			Action:    ActionStatusChanged,
+			Source:    SourceCallback,
+			Actor:     "gateway",
+			OldValue:  `{"status":"pending"}`,
+			NewValue:  `{"status":"completed"}`,
+			PrevHash:  h.Hash,
+		}
+		e.Hash = e.computeHash()
+		entries = append(entries, e)
+		h = head{ID: 1, Seq: e.ID, Hash: e.Hash}
+	}
+	return entries, h
+}
+
+func verifyChain(entries []Entry, h head) *Report {
+	var c chain
+	for _, e := range entries {
+		if !c.add(e) {
+			break
+		}
+	}
+	return c.report(h)
+}
+
+func TestVerify(t *testing.T) {
+	SetKey("test-audit-secret")
+	defer SetKey("")
+
+	tests := []struct {
+		name     string
+		tamper   func(entries []Entry, h head) ([]Entry, head)
+		valid    bool
+		brokenAt uint
+	}{
+		{
+			name:   "intact",
+			tamper: func(entries []Entry, h head) ([]Entry, head) { return entries, h },
+			valid:  true,
+		},
+		{
+			name: "empty",
+			tamper: func(entries []Entry, h head) ([]Entry, head) {
+				return nil, head{ID: 1}
+			},
+			valid: true,
+		},
+		{
+			name: "edited entry",
+			tamper: func(entries []Entry, h head) ([]Entry, head) {
+				entries[1].NewValue = `{"status":"failed"}`
+				return entries, h
+			},
+			brokenAt: 2,
+		},
+		{
+			name: "edited and rehashed without the key",
+			tamper: func(entries []Entry, h head) ([]Entry, head) {
+				SetKey("")
+				entries[2].Actor = "someone else"
+				entries[2].Hash = entries[2].computeHash()
+				SetKey("test-audit-secret")
+				entries[3].PrevHash = entries[2].Hash
+				return entries, h
+			},
+			brokenAt: 3,
+		},
+		{
+			name: "missing entry",
+			tamper: func(entries []Entry, h head) ([]Entry, head) {
+				return append(entries[:1:1], entries[2:]...), h
+			},
+			brokenAt: 3,
+		},
+		{
+			name: "reordered entries",
+			tamper: func(entries []Entry, h head) ([]Entry, head) {
+				entries[1], entries[2] = entries[2], entries[1]
+				entries[1].ID, entries[2].ID = 2, 3
+				return entries, h
+			},
+			brokenAt: 2,
+		},
+		{
+			name: "entries dropped from the end",
+			tamper: func(entries []Entry, h head) ([]Entry, head) {
+				return entries[:3], h
+			},
+			brokenAt: 3,
+		},
+		{
+			name: "head moved back",
+			tamper: func(entries []Entry, h head) ([]Entry, head) {
+				return entries, head{ID: 1, Seq: 3, Hash: entries[2].Hash}
+			},
+			brokenAt: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, h := tt.tamper(buildChain(4))
+			report := verifyChain(entries, h)
+			if report.Valid != tt.valid {
+				t.Fatalf("Valid = %v, want %v (problem: %s)", report.Valid, tt.valid, report.Problem)
+			}
+			if report.BrokenAt != tt.brokenAt {
+				t.Errorf("BrokenAt = %d, want %d (problem: %s)", report.BrokenAt, tt.brokenAt, report.Problem)
+			}
+		})
+	}
+}
+
+func TestVerifyWrongKey(t *testing.T) {
+	SetKey("test-audit-secret")
+	defer SetKey("")
+	entries, h := buildChain(2)
+
+	SetKey("a-different-secret")
+	report := verifyChain(entries, h)
+	if report.Valid || report.BrokenAt != 1 {
+		t.Errorf("chain verified under the wrong key: %+v", report)
+	}
+}
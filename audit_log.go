@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fedco/audit"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAuditLog lists audit entries, newest first, filtered by entity, entity_id, external_id,
+// source, actor and date.
+func (vs *VotingSystem) GetAuditLog(c *gin.Context) {
+	query := vs.DB.Model(&audit.Entry{}).Order("id DESC")
+
+	for _, column := range []string{"entity", "entity_id", "external_id", "source", "actor"} {
+		if v := c.Query(column); v != "" {
+			query = query.Where(column+" = ?", v)
+		}
+	}
+
+	query, err := applyDateRange(c, query, "created_at")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+	offset, _ := strconv.Atoi(c.Query("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	var entries []audit.Entry
+	if err := query.Limit(limit).Offset(offset).Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// VerifyAuditLog checks the whole hash chain, as `fedco verify-audit` does.
+func (vs *VotingSystem) VerifyAuditLog(c *gin.Context) {
+	report, err := audit.Verify(vs.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Admin roles, from most to least privileged.
+const (
+	RoleSuperAdmin      = "superadmin"
+	RoleElectionManager = "election_manager"
+	RoleAuditor         = "auditor"
+	RoleReadOnly        = "read_only"
+)
+
+// Role sets used to guard route groups.
+var (
+	superAdminRoles = []string{RoleSuperAdmin}
+	managerRoles    = []string{RoleSuperAdmin, RoleElectionManager}
+	auditorRoles    = []string{RoleSuperAdmin, RoleElectionManager, RoleAuditor}
+	readerRoles     = []string{RoleSuperAdmin, RoleElectionManager, RoleAuditor, RoleReadOnly}
+)
+
+// adminContextKey is where RequireRole stores the authenticated *AdminClaims.
+const adminContextKey = "admin"
+
+var errInvalidToken = errors.New("invalid or expired token")
+
+// AdminUser is someone allowed to manage elections or read the ledgers.
+type AdminUser struct {
+	gorm.Model
+	Username     string     `gorm:"uniqueIndex;type:varchar(64)" json:"username"`
+	PasswordHash string     `json:"-"`
+	Role         string     `gorm:"type:varchar(32)" json:"role"`
+	Active       bool       `gorm:"default:true" json:"active"`
+	LastLoginAt  *time.Time `json:"last_login_at"`
+}
+
+// AdminClaims is the payload of the HS256 JWT issued at login.
+type AdminClaims struct {
+	Subject  string `json:"sub"`
+	UserID   uint   `json:"uid"`
+	Role     string `json:"role"`
+	IssuedAt int64  `json:"iat"`
+	Expires  int64  `json:"exp"`
+}
+
+type LoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type NewAdminUserRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Role     string `json:"role" binding:"required"`
+}
+
+type UpdateAdminUserRequest struct {
+	Password string `json:"password"`
+	Role     string `json:"role"`
+	Active   *bool  `json:"active"`
+}
+
+// Auth issues and checks admin tokens.
+type Auth struct {
+	DB       *gorm.DB
+	Secret   []byte
+	TokenTTL time.Duration
+}
+
+// NewAuth creates an Auth signing tokens with secret. With no secret a random one is generated,
+// which means tokens stop working when the process restarts.
+func NewAuth(db *gorm.DB, secret string) *Auth {
+	key := []byte(secret)
+	if len(key) == 0 {
+		log.Println("WARNING: no JWT secret configured, generating a random one for this process")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic("failed to generate JWT secret")
+		}
+	}
+	return &Auth{DB: db, Secret: key, TokenTTL: 12 * time.Hour}
+}
+
+func validRole(role string) bool {
+	for _, r := range readerRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HashPassword returns the bcrypt hash stored on AdminUser.
+func HashPassword(password string) (string, error) {
+	if len(password) < 8 {
+		return "", errors.New("password must be at least 8 characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CreateAdminUser stores a new admin with a hashed password.
+func CreateAdminUser(db *gorm.DB, username, password, role string) (*AdminUser, error) {
+	if !validRole(role) {
+		return nil, fmt.Errorf("unknown role %q", role)
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := AdminUser{Username: strings.TrimSpace(username), PasswordHash: hash, Role: role, Active: true}
+	if err := db.Create(&user).Error; err != nil {
+		return nil, fmt.Errorf("failed to create admin user: %w", err)
+	}
+	return &user, nil
+}
+
+// IssueToken signs a token for user.
+func (a *Auth) IssueToken(user *AdminUser) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(a.TokenTTL)
+	claims := AdminClaims{
+		Subject:  user.Username,
+		UserID:   user.ID,
+		Role:     user.Role,
+		IssuedAt: now.Unix(),
+		Expires:  expires.Unix(),
+	}
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + a.sign(signingInput), expires, nil
+}
+
+// ParseToken verifies a token's signature and expiry and returns its claims.
+func (a *Auth) ParseToken(token string) (*AdminClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+
+	if !hmac.Equal([]byte(parts[2]), []byte(a.sign(parts[0]+"."+parts[1]))) {
+		return nil, errInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerJSON, &header) != nil || header.Alg != "HS256" {
+		return nil, errInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	var claims AdminClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errInvalidToken
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return nil, errInvalidToken
+	}
+
+	return &claims, nil
+}
+
+func (a *Auth) sign(signingInput string) string {
+	mac := hmac.New(sha256.New, a.Secret)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// RequireRole only lets through requests carrying a valid bearer token for an active admin
+// whose current role is one of roles.
+func (a *Auth) RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+
+		claims, err := a.ParseToken(strings.TrimPrefix(header, "Bearer "))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+
+		// Look the user up again so deactivation and role changes apply to live tokens.
+		var user AdminUser
+		if err := a.DB.First(&user, claims.UserID).Error; err != nil || !user.Active {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+		claims.Role = user.Role
+
+		allowed := false
+		for _, role := range roles {
+			if role == user.Role {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return
+		}
+
+		c.Set(adminContextKey, claims)
+		c.Next()
+	}
+}
+
+// currentAdmin returns the admin authenticated by RequireRole, if any.
+func currentAdmin(c *gin.Context) *AdminClaims {
+	if v, ok := c.Get(adminContextKey); ok {
+		if claims, ok := v.(*AdminClaims); ok {
+			return claims
+		}
+	}
+	return nil
+}
+
+// adminName is the username of the authenticated admin, for logs and the audit trail.
+func adminName(c *gin.Context) string {
+	if claims := currentAdmin(c); claims != nil {
+		return claims.Subject
+	}
+	return "unknown"
+}
+
+func (a *Auth) Login(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user AdminUser
+	err := a.DB.Where("username = ?", req.Username).First(&user).Error
+	if err != nil || !user.Active ||
+		bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+		log.Printf("Failed admin login for %q from %s", req.Username, c.ClientIP())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		return
+	}
+
+	token, expires, err := a.IssueToken(&user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue token"})
+		return
+	}
+
+	now := time.Now()
+	a.DB.Model(&user).Update("last_login_at", &now)
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":      token,
+		"expires_at": expires,
+		"user":       user,
+	})
+}
+
+func (a *Auth) Me(c *gin.Context) {
+	c.JSON(http.StatusOK, currentAdmin(c))
+}
+
+func (a *Auth) CreateAdminUserHandler(c *gin.Context) {
+	var req NewAdminUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing int64
+	a.DB.Model(&AdminUser{}).Where("username = ?", req.Username).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+		return
+	}
+
+	user, err := CreateAdminUser(a.DB, req.Username, req.Password, req.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Admin user created successfully", "user": user})
+}
+
+func (a *Auth) GetAdminUsers(c *gin.Context) {
+	var users []AdminUser
+	if err := a.DB.Order("id ASC").Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve admin users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+func (a *Auth) UpdateAdminUser(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req UpdateAdminUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user AdminUser
+	if err := a.DB.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Admin user not found"})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.Role != "" {
+		if !validRole(req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown role %q", req.Role)})
+			return
+		}
+		updates["role"] = req.Role
+	}
+	if req.Password != "" {
+		hash, err := HashPassword(req.Password)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		updates["password_hash"] = hash
+	}
+	if req.Active != nil {
+		updates["active"] = *req.Active
+	}
+
+	if admin := currentAdmin(c); admin != nil && admin.UserID == user.ID {
+		if (req.Active != nil && !*req.Active) || (req.Role != "" && req.Role != RoleSuperAdmin) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot demote or deactivate yourself"})
+			return
+		}
+	}
+
+	if len(updates) > 0 {
+		if err := a.DB.Model(&user).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update admin user"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Admin user updated successfully", "user": user})
+}
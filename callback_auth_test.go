@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func callbackContext(remoteAddr string, headers map[string]string, body string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/mpesa-callback", strings.NewReader(body))
+	c.Request.RemoteAddr = remoteAddr
+	for name, value := range headers {
+		c.Request.Header.Set(name, value)
+	}
+	return c
+}
+
+func TestCallbackVerifierVerify(t *testing.T) {
+	const secret = "callback-secret"
+	body := `{"externalId":"FEDCO-1","transactionStatus":"COMPLETED"}`
+	signature := hex.EncodeToString(SignCallback(secret, []byte(body)))
+
+	tests := []struct {
+		name       string
+		secret     string
+		allowed    []string
+		remoteAddr string
+		headers    map[string]string
+		body       string
+		wantErr    error
+	}{
+		{name: "nothing configured", remoteAddr: "203.0.113.9:4000", body: body},
+		{name: "valid signature", secret: secret, headers: map[string]string{"X-Signature": signature}, body: body},
+		{name: "valid prefixed signature", secret: secret, headers: map[string]string{"X-Signature": "sha256=" + signature}, body: body},
+		{name: "signature over another body", secret: secret, headers: map[string]string{"X-Signature": signature}, body: body + " ", wantErr: errCallbackSignature},
+		{name: "signature with the wrong key", secret: "other-secret", headers: map[string]string{"X-Signature": signature}, body: body, wantErr: errCallbackSignature},
+		{name: "signature not hex", secret: secret, headers: map[string]string{"X-Signature": "not-hex"}, body: body, wantErr: errCallbackSignature},
+		{name: "shared secret header", secret: secret, headers: map[string]string{"X-Callback-Secret": secret}, body: body},
+		{name: "wrong shared secret", secret: secret, headers: map[string]string{"X-Callback-Secret": "guess"}, body: body, wantErr: errCallbackSignature},
+		{
+			name:    "bad signature is not rescued by the shared secret",
+			secret:  secret,
+			headers: map[string]string{"X-Signature": "00", "X-Callback-Secret": secret},
+			body:    body,
+			wantErr: errCallbackSignature,
+		},
+		{name: "unsigned", secret: secret, body: body, wantErr: errCallbackSignature},
+		{name: "allowed address", allowed: []string{"198.51.100.0/24"}, remoteAddr: "198.51.100.7:4000", body: body},
+		{name: "allowed single address", allowed: []string{" 198.51.100.7 "}, remoteAddr: "198.51.100.7:4000", body: body},
+		{name: "address not allowed", allowed: []string{"198.51.100.0/24"}, remoteAddr: "203.0.113.9:4000", body: body, wantErr: errCallbackSource},
+		{
+			name:       "allowed address still needs the signature",
+			secret:     secret,
+			allowed:    []string{"198.51.100.0/24"},
+			remoteAddr: "198.51.100.7:4000",
+			body:       body,
+			wantErr:    errCallbackSignature,
+		},
+		{
+			name:       "signed request from a disallowed address",
+			secret:     secret,
+			allowed:    []string{"198.51.100.0/24"},
+			remoteAddr: "203.0.113.9:4000",
+			headers:    map[string]string{"X-Signature": signature},
+			body:       body,
+			wantErr:    errCallbackSource,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewCallbackVerifier(tt.secret, tt.allowed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			remoteAddr := tt.remoteAddr
+			if remoteAddr == "" {
+				remoteAddr = "192.0.2.1:4000"
+			}
+			c := callbackContext(remoteAddr, tt.headers, tt.body)
+			if err := v.Verify(c, []byte(tt.body)); err != tt.wantErr {
+				t.Errorf("Verify() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewCallbackVerifierInvalidEntry(t *testing.T) {
+	if _, err := NewCallbackVerifier("", []string{"198.51.100.0/33"}); err == nil {
+		t.Error("accepted an invalid CIDR")
+	}
+	if _, err := NewCallbackVerifier("", []string{"not-an-ip"}); err == nil {
+		t.Error("accepted an invalid IP")
+	}
+}
+
+func TestCallbackVerifierRequireVerified(t *testing.T) {
+	v, err := NewCallbackVerifier("aggregator-secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/ussd", v.RequireVerified(), func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+
+	body := "sessionId=1&phoneNumber=0712345678&text="
+	tests := []struct {
+		name       string
+		signature  string
+		wantStatus int
+	}{
+		{"signed", hex.EncodeToString(SignCallback("aggregator-secret", []byte(body))), http.StatusOK},
+		{"unsigned", "", http.StatusForbidden},
+		{"wrong signature", hex.EncodeToString(SignCallback("guess", []byte(body))), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/ussd", strings.NewReader(body))
+			if tt.signature != "" {
+				req.Header.Set("X-Signature", tt.signature)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && w.Body.String() != body {
+				t.Errorf("handler read %q, want the original body", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"errors"
+	"fedco/audit"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Delete policies for categories, positions and candidates that already have votes.
+const (
+	// DeleteBlock refuses the delete while any vote references the entry.
+	DeleteBlock = "block"
+	// DeleteCascade soft-deletes the entry's votes along with it. The payment ledger keeps its rows.
+	DeleteCascade = "cascade"
+)
+
+type UpdateCategoryRequest struct {
+	Name       string `json:"name"`
+	ElectionID *uint  `json:"election_id"`
+}
+
+type UpdatePositionRequest struct {
+	Name       string `json:"name"`
+	CategoryID uint   `json:"category_id"`
+}
+
+// UpdateCandidateRequest changes only the fields present; an empty string clears a profile field.
+type UpdateCandidateRequest struct {
+	Name       string  `json:"name"`
+	ShortCode  *string `json:"short_code"`
+	PositionID uint    `json:"position_id"`
+	Bio        *string `json:"bio"`
+	Website    *string `json:"website"`
+	Facebook   *string `json:"facebook"`
+	Instagram  *string `json:"instagram"`
+	Twitter    *string `json:"twitter"`
+	TikTok     *string `json:"tiktok"`
+}
+
+// candidateResponseColumns selects the columns of CandidateResponse from the candidates table.
+const candidateResponseColumns = "id, name, short_code, position_id, bio, website, facebook, instagram, twitter, tik_tok, photo_url, thumbnail_url"
+
+func candidateResponse(candidate Candidate) CandidateResponse {
+	return CandidateResponse{
+		ID:               candidate.ID,
+		Name:             candidate.Name,
+		ShortCode:        candidate.ShortCode,
+		PositionID:       candidate.PositionID,
+		CandidateProfile: candidate.CandidateProfile,
+		PhotoURL:         candidate.PhotoURL,
+		ThumbnailURL:     candidate.ThumbnailURL,
+	}
+}
+
+// Validate checks that every social and website link is an absolute http(s) URL.
+func (p CandidateProfile) Validate() error {
+	for name, link := range map[string]string{
+		"website":   p.Website,
+		"facebook":  p.Facebook,
+		"instagram": p.Instagram,
+		"twitter":   p.Twitter,
+		"tiktok":    p.TikTok,
+	} {
+		if link == "" {
+			continue
+		}
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s must be an http or https URL", name)
+		}
+	}
+	return nil
+}
+
+// findRecord loads the row named by the :id parameter into dst, answering the request itself
+// when the ID is invalid or the row does not exist.
+func (vs *VotingSystem) findRecord(c *gin.Context, dst interface{}, name string) bool {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s ID", strings.ToLower(name))})
+		return false
+	}
+
+	if err := vs.DB.First(dst, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("%s not found", name)})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to retrieve %s", strings.ToLower(name))})
+		return false
+	}
+	return true
+}
+
+// categoryNameTaken reports whether another category in the same election already uses name.
+func (vs *VotingSystem) categoryNameTaken(name string, electionID *uint, excludeID uint) bool {
+	query := vs.DB.Model(&Category{}).Where("name = ? AND id <> ?", strings.TrimSpace(name), excludeID)
+	if electionID != nil {
+		query = query.Where("election_id = ?", *electionID)
+	} else {
+		query = query.Where("election_id IS NULL")
+	}
+
+	var count int64
+	query.Count(&count)
+	return count > 0
+}
+
+// shortCodePattern is what a candidate short code may look like once upper-cased.
+var shortCodePattern = regexp.MustCompile(`^[A-Z0-9]{2,16}$`)
+
+// normalizeShortCode upper-cases a short code and checks it can be texted as one word.
+// An empty code is allowed and means the candidate has none.
+func normalizeShortCode(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code != "" && !shortCodePattern.MatchString(code) {
+		return "", errors.New("short_code must be 2 to 16 letters or digits")
+	}
+	return code, nil
+}
+
+func (vs *VotingSystem) shortCodeTaken(code string, excludeID uint) bool {
+	if code == "" {
+		return false
+	}
+
+	var count int64
+	vs.DB.Model(&Candidate{}).Where("short_code = ? AND id <> ?", code, excludeID).Count(&count)
+	return count > 0
+}
+
+// electionAcceptsChanges checks that an election exists and is not archived before categories
+// are attached to it.
+func (vs *VotingSystem) electionAcceptsChanges(c *gin.Context, electionID uint) bool {
+	var election Election
+	if err := vs.DB.First(&election, electionID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Election not found"})
+		return false
+	}
+	if election.Status == ElectionArchived {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Election is archived"})
+		return false
+	}
+	return true
+}
+
+func (vs *VotingSystem) GetCategory(c *gin.Context) {
+	var category Category
+	if !vs.findRecord(c, &category, "Category") {
+		return
+	}
+
+	c.JSON(http.StatusOK, CategoryResponse{ID: category.ID, Name: category.Name, ElectionID: category.ElectionID})
+}
+
+func (vs *VotingSystem) UpdateCategory(c *gin.Context) {
+	var category Category
+	if !vs.findRecord(c, &category, "Category") {
+		return
+	}
+
+	var req UpdateCategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if name := strings.TrimSpace(req.Name); name != "" {
+		category.Name = name
+	}
+	if req.ElectionID != nil {
+		if !vs.electionAcceptsChanges(c, *req.ElectionID) {
+			return
+		}
+		category.ElectionID = req.ElectionID
+	}
+
+	if vs.categoryNameTaken(category.Name, category.ElectionID, category.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A category with this name already exists"})
+		return
+	}
+
+	if err := vs.DB.Model(&category).Updates(map[string]interface{}{
+		"name":        category.Name,
+		"election_id": category.ElectionID,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category"})
+		return
+	}
+
+	vs.ResultsCache.Invalidate()
+	c.JSON(http.StatusOK, gin.H{"message": "Category updated successfully", "category": CategoryResponse{ID: category.ID, Name: category.Name, ElectionID: category.ElectionID}})
+}
+
+func (vs *VotingSystem) DeleteCategory(c *gin.Context) {
+	var category Category
+	if !vs.findRecord(c, &category, "Category") {
+		return
+	}
+
+	candidates := vs.DB.Model(&Candidate{}).Select("candidates.id").
+		Joins("JOIN positions ON positions.id = candidates.position_id").
+		Where("positions.category_id = ?", category.ID)
+
+	vs.deleteCatalogEntry(c, "Category", candidates, func(tx *gorm.DB) error {
+		if err := tx.Where("position_id IN (?)", tx.Model(&Position{}).Select("id").Where("category_id = ?", category.ID)).
+			Delete(&Candidate{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("category_id = ?", category.ID).Delete(&Position{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&category).Error
+	})
+}
+
+func (vs *VotingSystem) GetPosition(c *gin.Context) {
+	var position Position
+	if !vs.findRecord(c, &position, "Position") {
+		return
+	}
+
+	c.JSON(http.StatusOK, PositionResponse{ID: position.ID, Name: position.Name, CategoryID: position.CategoryID})
+}
+
+func (vs *VotingSystem) UpdatePosition(c *gin.Context) {
+	var position Position
+	if !vs.findRecord(c, &position, "Position") {
+		return
+	}
+
+	var req UpdatePositionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if name := strings.TrimSpace(req.Name); name != "" {
+		position.Name = name
+	}
+	if req.CategoryID != 0 {
+		var category Category
+		if err := vs.DB.First(&category, req.CategoryID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Category not found"})
+			return
+		}
+		position.CategoryID = req.CategoryID
+	}
+
+	if err := vs.DB.Model(&position).Updates(map[string]interface{}{
+		"name":        position.Name,
+		"category_id": position.CategoryID,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update position"})
+		return
+	}
+
+	vs.ResultsCache.Invalidate()
+	c.JSON(http.StatusOK, gin.H{"message": "Position updated successfully", "position": PositionResponse{ID: position.ID, Name: position.Name, CategoryID: position.CategoryID}})
+}
+
+func (vs *VotingSystem) DeletePosition(c *gin.Context) {
+	var position Position
+	if !vs.findRecord(c, &position, "Position") {
+		return
+	}
+
+	candidates := vs.DB.Model(&Candidate{}).Select("id").Where("position_id = ?", position.ID)
+
+	vs.deleteCatalogEntry(c, "Position", candidates, func(tx *gorm.DB) error {
+		if err := tx.Where("position_id = ?", position.ID).Delete(&Candidate{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&position).Error
+	})
+}
+
+func (vs *VotingSystem) GetCandidate(c *gin.Context) {
+	var candidate Candidate
+	if !vs.findRecord(c, &candidate, "Candidate") {
+		return
+	}
+
+	c.JSON(http.StatusOK, candidateResponse(candidate))
+}
+
+func (vs *VotingSystem) UpdateCandidate(c *gin.Context) {
+	var candidate Candidate
+	if !vs.findRecord(c, &candidate, "Candidate") {
+		return
+	}
+
+	var req UpdateCandidateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if name := strings.TrimSpace(req.Name); name != "" {
+		candidate.Name = name
+	}
+	if req.PositionID != 0 {
+		var position Position
+		if err := vs.DB.First(&position, req.PositionID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Position not found"})
+			return
+		}
+		candidate.PositionID = req.PositionID
+	}
+	if req.ShortCode != nil {
+		shortCode, err := normalizeShortCode(*req.ShortCode)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if vs.shortCodeTaken(shortCode, candidate.ID) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Another candidate already uses this short code"})
+			return
+		}
+		candidate.ShortCode = shortCode
+	}
+	for dst, value := range map[*string]*string{
+		&candidate.Bio:       req.Bio,
+		&candidate.Website:   req.Website,
+		&candidate.Facebook:  req.Facebook,
+		&candidate.Instagram: req.Instagram,
+		&candidate.Twitter:   req.Twitter,
+		&candidate.TikTok:    req.TikTok,
+	} {
+		if value != nil {
+			*dst = strings.TrimSpace(*value)
+		}
+	}
+	if err := candidate.CandidateProfile.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := vs.DB.Model(&candidate).Updates(map[string]interface{}{
+		"name":        candidate.Name,
+		"short_code":  candidate.ShortCode,
+		"position_id": candidate.PositionID,
+		"bio":         candidate.Bio,
+		"website":     candidate.Website,
+		"facebook":    candidate.Facebook,
+		"instagram":   candidate.Instagram,
+		"twitter":     candidate.Twitter,
+		"tik_tok":     candidate.TikTok,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update candidate"})
+		return
+	}
+
+	vs.ResultsCache.Invalidate()
+	c.JSON(http.StatusOK, gin.H{"message": "Candidate updated successfully", "candidate": candidateResponse(candidate)})
+}
+
+func (vs *VotingSystem) DeleteCandidate(c *gin.Context) {
+	var candidate Candidate
+	if !vs.findRecord(c, &candidate, "Candidate") {
+		return
+	}
+
+	candidates := vs.DB.Model(&Candidate{}).Select("id").Where("id = ?", candidate.ID)
+
+	vs.deleteCatalogEntry(c, "Candidate", candidates, func(tx *gorm.DB) error {
+		return tx.Delete(&candidate).Error
+	})
+}
+
+// deleteCatalogEntry applies the delete policy to the votes cast for candidates, then runs
+// remove in the same transaction. The votes are locked while the policy is decided, so a vote
+// saved meanwhile cannot slip past it, and each cascaded vote gets its own audit entry.
+func (vs *VotingSystem) deleteCatalogEntry(c *gin.Context, name string, candidates *gorm.DB, remove func(tx *gorm.DB) error) {
+	tx := vs.DB.Begin()
+	var cast []Vote
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id, external_id, candidate_id, status, amount, vote_count").
+		Where("candidate_id IN (?)", candidates).
+		Find(&cast).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count votes"})
+		return
+	}
+	votes := len(cast)
+
+	if votes > 0 && vs.DeletePolicy != DeleteCascade {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%s has votes and cannot be deleted", name), "votes": votes})
+		return
+	}
+
+	if votes > 0 {
+		if err := tx.Where("candidate_id IN (?)", candidates).Delete(&Vote{}).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete votes"})
+			return
+		}
+		for i := range cast {
+			vote := &cast[i]
+			if err := audit.Record(tx, audit.Vote(vote, audit.ActionDeleted, audit.SourceAdmin, adminName(c),
+				gin.H{"status": vote.Status, "candidate_id": vote.CandidateID, "amount": vote.Amount, "vote_count": vote.VoteCount},
+				gin.H{"deleted": true, "with": fmt.Sprintf("%s %s", strings.ToLower(name), c.Param("id"))})); err != nil {
+				tx.Rollback()
+				log.Printf("Failed to audit cascaded delete of vote %s: %s", vote.ExternalID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete votes"})
+				return
+			}
+		}
+	}
+
+	if err := remove(tx); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to delete %s", strings.ToLower(name))})
+		return
+	}
+
+	entityID, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err := audit.Record(tx, &audit.Entry{
+		Entity:   strings.ToLower(name),
+		EntityID: uint(entityID),
+		Action:   audit.ActionDeleted,
+		Source:   audit.SourceAdmin,
+		Actor:    adminName(c),
+		NewValue: audit.Values(gin.H{"deleted": true, "votes_deleted": votes}),
+	}); err != nil {
+		tx.Rollback()
+		log.Printf("Failed to audit delete of %s %s: %s", strings.ToLower(name), c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to delete %s", strings.ToLower(name))})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to delete %s", strings.ToLower(name))})
+		return
+	}
+
+	if votes > 0 {
+		log.Printf("%s %s deleted by %s with %d votes cascaded", name, c.Param("id"), adminName(c), votes)
+	}
+
+	vs.ResultsCache.Invalidate()
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("%s deleted successfully", name), "votes_deleted": votes})
+}
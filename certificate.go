@@ -0,0 +1,332 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fedco/models"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ResultsCertificate records every results PDF issued, with the hash of the vote ledger the
+// figures were computed from, so a printed certificate can be checked against the database.
+type ResultsCertificate struct {
+	gorm.Model
+	ElectionID uint `gorm:"index" json:"election_id"`
+	// Provisional is set when the certificate was issued before the election closed.
+	Provisional bool   `json:"provisional"`
+	LedgerHash  string `gorm:"type:char(64);index" json:"ledger_hash"`
+	// VoteCount and LastVoteID describe the completed votes covered by LedgerHash; LastVoteID
+	// is 0 when there were none. The votes themselves are listed in CertificateVote.
+	VoteCount   int    `json:"vote_count"`
+	LastVoteID  uint   `json:"last_vote_id"`
+	TotalVotes  int    `json:"total_votes"`
+	TotalAmount int    `json:"total_amount"`
+	GeneratedBy string `json:"generated_by"`
+}
+
+// CertificateVote lists each vote a certificate's ledger hash covered. Votes that complete
+// after the certificate was issued, whatever their ID, are not part of it.
+type CertificateVote struct {
+	CertificateID uint `gorm:"primaryKey;autoIncrement:false"`
+	VoteID        uint `gorm:"primaryKey;autoIncrement:false"`
+}
+
+func (CertificateVote) TableName() string { return "results_certificate_votes" }
+
+// LedgerHash is the SHA-256 of an election's completed votes, one line per vote in ID order:
+//
+//	<vote id>|<external id>|<candidate id>|<amount>|<votes bought>\n
+//
+// It also returns the IDs of the votes hashed. An election without completed votes hashes to
+// the SHA-256 of nothing.
+func LedgerHash(db *gorm.DB, electionID uint) (string, []uint, error) {
+	return ledgerHash(db.Model(&Vote{}).
+		Joins("JOIN candidates ON candidates.id = votes.candidate_id").
+		Joins("JOIN positions ON positions.id = candidates.position_id").
+		Joins("JOIN categories ON categories.id = positions.category_id").
+		Where("categories.election_id = ? AND votes.status = ?", electionID, models.VoteCompleted))
+}
+
+// CertifiedLedgerHash hashes, as LedgerHash does, the votes a certificate covered as they are
+// now. A covered vote that is no longer completed, or was deleted, changes the hash.
+func CertifiedLedgerHash(db *gorm.DB, certificateID uint) (string, []uint, error) {
+	return ledgerHash(db.Model(&Vote{}).
+		Joins("JOIN results_certificate_votes ON results_certificate_votes.vote_id = votes.id").
+		Where("results_certificate_votes.certificate_id = ? AND votes.status = ?", certificateID, models.VoteCompleted))
+}
+
+func ledgerHash(query *gorm.DB) (string, []uint, error) {
+	rows, err := query.
+		Select("votes.id, votes.external_id, votes.candidate_id, votes.amount, " + legacyVoteCountExpr).
+		Order("votes.id ASC").
+		Rows()
+	if err != nil {
+		return "", nil, err
+	}
+	defer rows.Close()
+
+	hash := sha256.New()
+	var ids []uint
+	for rows.Next() {
+		var id, candidateID uint
+		var externalID string
+		var amount, votes int
+		if err := rows.Scan(&id, &externalID, &candidateID, &amount, &votes); err != nil {
+			return "", nil, err
+		}
+		fmt.Fprintf(hash, "%d|%s|%d|%d|%d\n", id, externalID, candidateID, amount, votes)
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return "", nil, err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), ids, nil
+}
+
+// ResultsCertificatePDF issues a results certificate for an election as a PDF. Certificates
+// for elections that have not closed yet are marked provisional.
+func (vs *VotingSystem) ResultsCertificatePDF(c *gin.Context) {
+	election, ok := vs.findElection(c)
+	if !ok {
+		return
+	}
+
+	// Hash the ledger and build the results from the same snapshot so the figures match the hash.
+	tx := vs.DB.Begin(&sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	hash, voteIDs, err := LedgerHash(tx, election.ID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash vote ledger"})
+		return
+	}
+	results, err := buildResults(tx, election.ID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve results"})
+		return
+	}
+	tx.Commit()
+
+	certificate := ResultsCertificate{
+		ElectionID:  election.ID,
+		Provisional: election.Status != ElectionClosed && election.Status != ElectionArchived,
+		LedgerHash:  hash,
+		VoteCount:   len(voteIDs),
+		GeneratedBy: adminName(c),
+	}
+	if len(voteIDs) > 0 {
+		certificate.LastVoteID = voteIDs[len(voteIDs)-1]
+	}
+	for _, category := range results {
+		for _, position := range category.Positions {
+			for _, candidate := range position.Candidates {
+				certificate.TotalVotes += candidate.VoteCount
+				certificate.TotalAmount += candidate.Amount
+			}
+		}
+	}
+
+	tx = vs.DB.Begin()
+	if err := tx.Create(&certificate).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record certificate"})
+		return
+	}
+	if len(voteIDs) > 0 {
+		covered := make([]CertificateVote, len(voteIDs))
+		for i, id := range voteIDs {
+			covered[i] = CertificateVote{CertificateID: certificate.ID, VoteID: id}
+		}
+		if err := tx.CreateInBatches(covered, 1000).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record certificate"})
+			return
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record certificate"})
+		return
+	}
+
+	pdf := renderResultsCertificate(election, &certificate, results)
+	filename := fmt.Sprintf("results-certificate-%d-%d.pdf", election.ID, certificate.ID)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("X-Ledger-Hash", hash)
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
+func renderResultsCertificate(election *Election, certificate *ResultsCertificate, results []CategoryResult) []byte {
+	doc := newPDFWriter()
+	left := pdfMargin
+
+	title := "Official Results Certificate"
+	if certificate.Provisional {
+		title = "Provisional Results Certificate"
+	}
+	doc.Line(20, true, pdfCell{X: left, Text: title})
+	doc.Line(14, true, pdfCell{X: left, Text: pdfTruncate(election.Name, 60)})
+	doc.Space(4)
+
+	window := "open-ended"
+	if election.StartsAt != nil || election.EndsAt != nil {
+		from, to := "-", "-"
+		if election.StartsAt != nil {
+			from = election.StartsAt.Format("2006-01-02 15:04")
+		}
+		if election.EndsAt != nil {
+			to = election.EndsAt.Format("2006-01-02 15:04")
+		}
+		window = from + " to " + to
+	}
+	details := [][2]string{
+		{"Certificate no.", strconv.FormatUint(uint64(certificate.ID), 10)},
+		{"Election status", election.Status},
+		{"Voting window", window},
+		{"Issued", certificate.CreatedAt.Format("2006-01-02 15:04:05 MST")},
+		{"Issued by", certificate.GeneratedBy},
+		{"Total votes", strconv.Itoa(certificate.TotalVotes)},
+		{"Total amount", strconv.Itoa(certificate.TotalAmount)},
+	}
+	for _, d := range details {
+		doc.Line(10, false, pdfCell{X: left, Text: d[0]}, pdfCell{X: left + 110, Text: d[1]})
+	}
+	if certificate.Provisional {
+		doc.Space(4)
+		doc.Line(10, true, pdfCell{X: left, Text: "Voting had not closed when this certificate was issued. Figures may still change."})
+	}
+	doc.Rule()
+
+	columns := []float64{left, left + 40, left + 320, left + 400}
+	for _, category := range results {
+		doc.Space(6)
+		doc.Line(13, true, pdfCell{X: left, Text: pdfTruncate(category.Name, 70)})
+
+		for _, position := range category.Positions {
+			doc.Space(2)
+			doc.Line(11, true, pdfCell{X: left, Text: pdfTruncate(position.Name, 80)})
+			if len(position.Candidates) == 0 {
+				doc.Line(9, false, pdfCell{X: left, Text: "No candidates"})
+				continue
+			}
+
+			doc.Line(8, true,
+				pdfCell{X: columns[0], Text: "Rank"},
+				pdfCell{X: columns[1], Text: "Candidate"},
+				pdfCell{X: columns[2], Text: "Votes"},
+				pdfCell{X: columns[3], Text: "Share"},
+			)
+
+			winners := positionWinners(position.Candidates)
+			for i, candidate := range rankCandidates(position.Candidates) {
+				name := candidate.Name
+				if winners[candidate.ID] && len(winners) > 1 {
+					name += "  (tied winner)"
+				} else if winners[candidate.ID] {
+					name += "  (winner)"
+				}
+				doc.Line(9, winners[candidate.ID],
+					pdfCell{X: columns[0], Text: strconv.Itoa(i + 1)},
+					pdfCell{X: columns[1], Text: pdfTruncate(name, 55)},
+					pdfCell{X: columns[2], Text: strconv.Itoa(candidate.VoteCount)},
+					pdfCell{X: columns[3], Text: fmt.Sprintf("%.0f%%", candidate.VotePercentage)},
+				)
+			}
+		}
+	}
+
+	doc.Space(10)
+	doc.Rule()
+	doc.Line(10, true, pdfCell{X: left, Text: "Ledger verification"})
+	doc.Line(8, false, pdfCell{X: left, Text: "SHA-256 of the completed vote ledger:"})
+	doc.Line(9, true, pdfCell{X: left, Text: certificate.LedgerHash})
+	if certificate.VoteCount == 0 {
+		doc.Line(8, false, pdfCell{X: left, Text: "Covers no completed vote payments."})
+	} else {
+		doc.Line(8, false, pdfCell{X: left, Text: fmt.Sprintf("Covers %d completed vote payments up to vote #%d.", certificate.VoteCount, certificate.LastVoteID)})
+	}
+	doc.Line(8, false, pdfCell{X: left, Text: fmt.Sprintf("Check it any time at /certificates/%d/verify?hash=<hash above>.", certificate.ID)})
+
+	return doc.Bytes(fmt.Sprintf("Certificate %d  -  ledger %s", certificate.ID, certificate.LedgerHash))
+}
+
+// rankCandidates orders candidates by votes bought, highest first, keeping ties in their
+// existing order.
+func rankCandidates(candidates []CandidateResult) []CandidateResult {
+	ranked := make([]CandidateResult, len(candidates))
+	copy(ranked, candidates)
+	for i := 1; i < len(ranked); i++ {
+		for j := i; j > 0 && ranked[j].VoteCount > ranked[j-1].VoteCount; j-- {
+			ranked[j], ranked[j-1] = ranked[j-1], ranked[j]
+		}
+	}
+	return ranked
+}
+
+// positionWinners returns the candidates sharing the highest vote count, if anyone has votes.
+func positionWinners(candidates []CandidateResult) map[uint]bool {
+	top := 0
+	for _, candidate := range candidates {
+		if candidate.VoteCount > top {
+			top = candidate.VoteCount
+		}
+	}
+
+	winners := make(map[uint]bool)
+	if top == 0 {
+		return winners
+	}
+	for _, candidate := range candidates {
+		if candidate.VoteCount == top {
+			winners[candidate.ID] = true
+		}
+	}
+	return winners
+}
+
+// VerifyResultsCertificate recomputes a certificate's ledger hash over the votes it covered and
+// reports whether the database still matches it. Votes completed since do not count. An optional hash parameter is compared with
+// the recorded hash, to check a printed copy.
+func (vs *VotingSystem) VerifyResultsCertificate(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid certificate ID"})
+		return
+	}
+
+	var certificate ResultsCertificate
+	if err := vs.DB.First(&certificate, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve certificate"})
+		return
+	}
+
+	current, voteIDs, err := CertifiedLedgerHash(vs.DB, certificate.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash vote ledger"})
+		return
+	}
+
+	response := gin.H{
+		"certificate":    certificate,
+		"current_hash":   current,
+		"current_count":  len(voteIDs),
+		"ledger_matches": current == certificate.LedgerHash,
+	}
+	if given := c.Query("hash"); given != "" {
+		response["hash_matches_certificate"] = strings.EqualFold(strings.TrimSpace(given), certificate.LedgerHash)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
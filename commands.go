@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fedco/audit"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gorm.io/gorm"
+)
+
+// runCommand runs a maintenance subcommand such as `fedco merge-voters` instead of the HTTP server.
+func runCommand(db *gorm.DB, cfg *Config, args []string) error {
+	switch args[0] {
+	case "merge-voters":
+		fs := flag.NewFlagSet("merge-voters", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "report what would be merged without changing anything")
+		fs.Parse(args[1:])
+
+		report, err := MergeDuplicateVoters(db, *dryRun)
+		if report != nil {
+			printJSON(report)
+		}
+		if err != nil {
+			return err
+		}
+		if !*dryRun {
+			return db.AutoMigrate(&Voter{})
+		}
+		return nil
+	case "create-admin":
+		fs := flag.NewFlagSet("create-admin", flag.ExitOnError)
+		username := fs.String("username", "", "admin username")
+		role := fs.String("role", RoleSuperAdmin, "superadmin, election_manager, auditor or read_only")
+		fs.Parse(args[1:])
+
+		// Read the password from the environment so it does not end up in shell history.
+		password := os.Getenv("FEDCO_ADMIN_PASSWORD")
+		if *username == "" || password == "" {
+			return fmt.Errorf("usage: FEDCO_ADMIN_PASSWORD=... fedco create-admin -username NAME [-role ROLE]")
+		}
+
+		if err := db.AutoMigrate(&AdminUser{}); err != nil {
+			return err
+		}
+		user, err := CreateAdminUser(db, *username, password, *role)
+		if err != nil {
+			return err
+		}
+		printJSON(user)
+		return nil
+	case "import-settlement":
+		fs := flag.NewFlagSet("import-settlement", flag.ExitOnError)
+		file := fs.String("file", "", "settlement statement CSV")
+		apply := fs.Bool("apply", false, "complete paid-but-pending votes and correct amount mismatches")
+		applyUnpaid := fs.Bool("apply-unpaid", false, "with -apply, also fail completed votes the statement shows as unpaid")
+		from := fs.String("from", "", "start of the period checked for unpaid votes, YYYY-MM-DD or RFC3339")
+		to := fs.String("to", "", "end of the period checked for unpaid votes, YYYY-MM-DD or RFC3339")
+		fs.Parse(args[1:])
+
+		if *file == "" {
+			return fmt.Errorf("usage: fedco import-settlement -file STATEMENT.csv [-apply [-apply-unpaid]] [-from DATE] [-to DATE]")
+		}
+		if *applyUnpaid && !*apply {
+			return fmt.Errorf("-apply-unpaid requires -apply")
+		}
+
+		opts := SettlementOptions{Filename: filepath.Base(*file), Apply: *apply, ApplyUnpaid: *applyUnpaid, Actor: "cli"}
+		if user := os.Getenv("USER"); user != "" {
+			opts.Actor = "cli:" + user
+		}
+		if *from != "" {
+			t, err := parseDateParam(*from, false)
+			if err != nil {
+				return err
+			}
+			opts.From = &t
+		}
+		if *to != "" {
+			t, err := parseDateParam(*to, true)
+			if err != nil {
+				return err
+			}
+			opts.To = &t
+		}
+
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := db.AutoMigrate(&Vote{}, &PaymentTransaction{}, &SettlementImport{}, &SettlementLine{}, &Notification{},
+			&WebhookSubscription{}, &WebhookDelivery{}); err != nil {
+			return err
+		}
+		// Receipts and webhooks are queued here and sent by the server's workers.
+		vs := &VotingSystem{DB: db}
+		if cfg.Notifications.Enabled {
+			if vs.Notifier, err = NewNotifier(cfg.Notifications); err != nil {
+				return err
+			}
+		}
+		opts.StatusChanged = vs.voteStatusChanged
+		if err := audit.Migrate(db); err != nil {
+			return err
+		}
+		imp, err := ImportSettlement(db, f, opts)
+		if err != nil {
+			return err
+		}
+
+		// Print the summary and only the lines that need attention.
+		var flagged []SettlementLine
+		for _, line := range imp.Lines {
+			if line.Issue != SettlementOK {
+				flagged = append(flagged, line)
+			}
+		}
+		imp.Lines = flagged
+		printJSON(imp)
+		return nil
+	case "verify-audit":
+		report, err := audit.Verify(db)
+		if err != nil {
+			return err
+		}
+		printJSON(report)
+		if !report.Valid {
+			return fmt.Errorf("audit log is broken at entry %d: %s", report.BrokenAt, report.Problem)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,747 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Secret is a config string that must never be printed. It formats as ******** so a
+// Config can be logged safely.
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "********"
+}
+
+func (s Secret) GoString() string { return strconv.Quote(s.String()) }
+
+func (s Secret) MarshalJSON() ([]byte, error) { return []byte(strconv.Quote(s.String())), nil }
+
+func (s Secret) MarshalYAML() (interface{}, error) { return s.String(), nil }
+
+// Value returns the secret itself, for handing to the code that needs it.
+func (s Secret) Value() string { return string(s) }
+
+type ServerConfig struct {
+	Addr           string   `yaml:"addr"`
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	CORSOrigins    []string `yaml:"cors_origins"`
+}
+
+type DatabaseConfig struct {
+	DSN Secret `yaml:"dsn"`
+}
+
+type GatewayConfig struct {
+	// Provider is "mamlaka" for the live gateway or "fake" for the in-memory one.
+	Provider           string        `yaml:"provider"`
+	BaseURL            string        `yaml:"base_url"`
+	MerchantID         string        `yaml:"merchant_id"`
+	Token              Secret        `yaml:"token"`
+	CallbackURL        string        `yaml:"callback_url"`
+	InsecureSkipVerify bool          `yaml:"insecure_skip_verify"`
+	Timeout            time.Duration `yaml:"timeout"`
+	// StatusAction is the merchant API action the reconciler queries payment status with.
+	StatusAction string `yaml:"status_action"`
+}
+
+type CallbackConfig struct {
+	Secret     Secret   `yaml:"secret"`
+	AllowedIPs []string `yaml:"allowed_ips"`
+}
+
+// AuditConfig keys the audit log's hash chain. The secret must stay out of the database and must
+// not change once entries have been written, or the existing chain no longer verifies.
+type AuditConfig struct {
+	Secret Secret `yaml:"secret"`
+}
+
+type AuthConfig struct {
+	JWTSecret Secret        `yaml:"jwt_secret"`
+	TokenTTL  time.Duration `yaml:"token_ttl"`
+}
+
+type ReconcileConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Interval    time.Duration `yaml:"interval"`
+	MinAge      time.Duration `yaml:"min_age"`
+	ExpireAfter time.Duration `yaml:"expire_after"`
+	BatchSize   int           `yaml:"batch_size"`
+}
+
+type CatalogConfig struct {
+	// DeletePolicy is "block" or "cascade"; see DeleteBlock and DeleteCascade.
+	DeletePolicy string `yaml:"delete_policy"`
+}
+
+type MediaConfig struct {
+	// Store is "local" (files under Dir, served at /media) or "s3".
+	Store         string   `yaml:"store"`
+	Dir           string   `yaml:"dir"`
+	PublicURL     string   `yaml:"public_url"`
+	MaxUploadSize int      `yaml:"max_upload_size"`
+	ThumbnailSize int      `yaml:"thumbnail_size"`
+	S3            S3Config `yaml:"s3"`
+}
+
+// SenderConfig picks the transport for one messaging channel.
+type SenderConfig struct {
+	// Sender is "log" (write outgoing messages to the log) or "file" (append them to File as
+	// JSON lines).
+	Sender string `yaml:"sender"`
+	File   string `yaml:"file"`
+}
+
+type NotificationConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Channel is where vote receipts are sent: "sms" or "whatsapp".
+	Channel     string        `yaml:"channel"`
+	Interval    time.Duration `yaml:"interval"`
+	MaxAttempts int           `yaml:"max_attempts"`
+	BatchSize   int           `yaml:"batch_size"`
+	// Templates replace the built-in message templates by name; see notificationTemplates.
+	Templates map[string]string `yaml:"templates"`
+}
+
+// RateLimitConfig sets the default vote limits and where their buckets are kept.
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Backend is "memory" (per process) or "redis" (shared by every replica).
+	Backend string      `yaml:"backend"`
+	Redis   RedisConfig `yaml:"redis"`
+	Limits  VoteLimits  `yaml:"limits"`
+	// LimitIPs applies limits.ip_per_hour to the client address. Turn it on when voters connect
+	// directly, or behind a load balancer listed in server.trusted_proxies; otherwise every voter
+	// shares the balancer's address.
+	LimitIPs bool `yaml:"limit_ips"`
+}
+
+type RedisConfig struct {
+	Addr     string        `yaml:"addr"`
+	Password Secret        `yaml:"password"`
+	DB       int           `yaml:"db"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// WebhookConfig drives the dispatcher that delivers partner webhooks.
+type WebhookConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Interval    time.Duration `yaml:"interval"`
+	MaxAttempts int           `yaml:"max_attempts"`
+	BatchSize   int           `yaml:"batch_size"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+// SimulatorConfig drives the simulate-gateway command, a stand-in for the mam-laka API.
+// The ratios are relative weights and need not add up to 1.
+type SimulatorConfig struct {
+	Addr         string        `yaml:"addr"`
+	Delay        time.Duration `yaml:"delay"`
+	Jitter       time.Duration `yaml:"jitter"`
+	SuccessRatio float64       `yaml:"success_ratio"`
+	FailureRatio float64       `yaml:"failure_ratio"`
+	TimeoutRatio float64       `yaml:"timeout_ratio"`
+	// CallbackURL, when set, receives every callback instead of the payment's callbackUrl.
+	CallbackURL string `yaml:"callback_url"`
+}
+
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey Secret `yaml:"secret_key"`
+	PathStyle bool   `yaml:"path_style"`
+}
+
+// Config is everything that differs between dev, staging and production.
+type Config struct {
+	// Environment is "development", "staging" or "production". Production refuses to start
+	// without callback and JWT secrets.
+	Environment string         `yaml:"environment"`
+	Server      ServerConfig   `yaml:"server"`
+	Database    DatabaseConfig `yaml:"database"`
+	Gateway     GatewayConfig  `yaml:"gateway"`
+	// TestGateway backs the /mpesa STK test endpoint. Fields left empty fall back to Gateway.
+	TestGateway GatewayConfig  `yaml:"test_gateway"`
+	Callback    CallbackConfig `yaml:"callback"`
+	// Aggregator authenticates the USSD and SMS aggregator posting to /ussd and /sms/inbound.
+	Aggregator    CallbackConfig     `yaml:"aggregator"`
+	Auth          AuthConfig         `yaml:"auth"`
+	Audit         AuditConfig        `yaml:"audit"`
+	Reconcile     ReconcileConfig    `yaml:"reconcile"`
+	Catalog       CatalogConfig      `yaml:"catalog"`
+	Media         MediaConfig        `yaml:"media"`
+	SMS           SenderConfig       `yaml:"sms"`
+	WhatsApp      SenderConfig       `yaml:"whatsapp"`
+	Notifications NotificationConfig `yaml:"notifications"`
+	Webhooks      WebhookConfig      `yaml:"webhooks"`
+	RateLimit     RateLimitConfig    `yaml:"rate_limit"`
+	Simulator     SimulatorConfig    `yaml:"simulator"`
+}
+
+// DefaultConfig holds the values used when neither the config file nor the environment sets them.
+func DefaultConfig() *Config {
+	return &Config{
+		Environment: "development",
+		Server: ServerConfig{
+			Addr:        ":8081",
+			CORSOrigins: []string{"*"},
+		},
+		Gateway: GatewayConfig{
+			Provider:     "mamlaka",
+			BaseURL:      mamlakaDefaultBaseURL,
+			CallbackURL:  "https://fedcoapi.mam-laka.com/mpesa-callback",
+			StatusAction: mamlakaStatusAction,
+			Timeout:      30 * time.Second,
+		},
+		Auth: AuthConfig{TokenTTL: 12 * time.Hour},
+		Reconcile: ReconcileConfig{
+			Enabled:     true,
+			Interval:    time.Minute,
+			MinAge:      2 * time.Minute,
+			ExpireAfter: 24 * time.Hour,
+			BatchSize:   100,
+		},
+		Catalog: CatalogConfig{DeletePolicy: DeleteBlock},
+		Media: MediaConfig{
+			Store:         "local",
+			Dir:           "uploads",
+			PublicURL:     localMediaRoute,
+			MaxUploadSize: 5 << 20,
+			ThumbnailSize: 320,
+			S3:            S3Config{Region: "us-east-1", PathStyle: true},
+		},
+		SMS:      SenderConfig{Sender: "log", File: "sms-outbox.jsonl"},
+		WhatsApp: SenderConfig{Sender: "log", File: "whatsapp-outbox.jsonl"},
+		Notifications: NotificationConfig{
+			Enabled:     true,
+			Channel:     ChannelSMS,
+			Interval:    15 * time.Second,
+			MaxAttempts: 8,
+			BatchSize:   50,
+		},
+		Webhooks: WebhookConfig{
+			Enabled:     true,
+			Interval:    5 * time.Second,
+			MaxAttempts: 10,
+			BatchSize:   100,
+			Timeout:     10 * time.Second,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled: true,
+			Backend: "memory",
+			Redis:   RedisConfig{Addr: "localhost:6379", Timeout: time.Second},
+			Limits: VoteLimits{
+				PhonePerHour: 10,
+				IPPerHour:    60,
+			},
+		},
+		Simulator: SimulatorConfig{
+			Addr:         ":8090",
+			Delay:        3 * time.Second,
+			Jitter:       2 * time.Second,
+			SuccessRatio: 0.8,
+			FailureRatio: 0.15,
+			TimeoutRatio: 0.05,
+		},
+	}
+}
+
+// LoadConfig reads the optional YAML file named by FEDCO_CONFIG, applies FEDCO_* environment
+// overrides on top, and validates the result.
+func LoadConfig() (*Config, error) {
+	cfg, err := ReadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ReadConfig is LoadConfig without the validation, for commands such as simulate-gateway that
+// use only part of the configuration and check that part themselves.
+func ReadConfig() (*Config, error) {
+	cfg := DefaultConfig()
+
+	if path := os.Getenv("FEDCO_CONFIG"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	cfg.TestGateway = cfg.TestGateway.withDefaults(cfg.Gateway)
+	return cfg, nil
+}
+
+func (cfg *Config) applyEnv(lookup func(string) (string, bool)) error {
+	str := func(name string, dst *string) {
+		if v, ok := lookup(name); ok {
+			*dst = v
+		}
+	}
+	secret := func(name string, dst *Secret) {
+		if v, ok := lookup(name); ok {
+			*dst = Secret(v)
+		}
+	}
+	list := func(name string, dst *[]string) {
+		if v, ok := lookup(name); ok {
+			*dst = splitList(v)
+		}
+	}
+
+	var errs []error
+	duration := func(name string, dst *time.Duration) {
+		if v, ok := lookup(name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = d
+		}
+	}
+	boolean := func(name string, dst *bool) {
+		if v, ok := lookup(name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = b
+		}
+	}
+	integer := func(name string, dst *int) {
+		if v, ok := lookup(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = n
+		}
+	}
+
+	number := func(name string, dst *float64) {
+		if v, ok := lookup(name); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = f
+		}
+	}
+
+	str("FEDCO_ENV", &cfg.Environment)
+
+	str("FEDCO_ADDR", &cfg.Server.Addr)
+	if port, ok := lookup("PORT"); ok {
+		cfg.Server.Addr = ":" + port
+	}
+	list("FEDCO_TRUSTED_PROXIES", &cfg.Server.TrustedProxies)
+	list("FEDCO_CORS_ORIGINS", &cfg.Server.CORSOrigins)
+
+	secret("FEDCO_DB_DSN", &cfg.Database.DSN)
+
+	str("FEDCO_PAYMENT_PROVIDER", &cfg.Gateway.Provider)
+	str("FEDCO_GATEWAY_URL", &cfg.Gateway.BaseURL)
+	str("FEDCO_GATEWAY_MERCHANT_ID", &cfg.Gateway.MerchantID)
+	secret("FEDCO_GATEWAY_TOKEN", &cfg.Gateway.Token)
+	str("FEDCO_GATEWAY_CALLBACK_URL", &cfg.Gateway.CallbackURL)
+	str("FEDCO_GATEWAY_STATUS_ACTION", &cfg.Gateway.StatusAction)
+	boolean("FEDCO_GATEWAY_INSECURE_SKIP_VERIFY", &cfg.Gateway.InsecureSkipVerify)
+	duration("FEDCO_GATEWAY_TIMEOUT", &cfg.Gateway.Timeout)
+
+	str("FEDCO_TEST_GATEWAY_MERCHANT_ID", &cfg.TestGateway.MerchantID)
+	secret("FEDCO_TEST_GATEWAY_TOKEN", &cfg.TestGateway.Token)
+	str("FEDCO_TEST_GATEWAY_CALLBACK_URL", &cfg.TestGateway.CallbackURL)
+
+	secret("FEDCO_CALLBACK_SECRET", &cfg.Callback.Secret)
+	list("FEDCO_CALLBACK_ALLOWED_IPS", &cfg.Callback.AllowedIPs)
+	secret("FEDCO_AGGREGATOR_SECRET", &cfg.Aggregator.Secret)
+	list("FEDCO_AGGREGATOR_ALLOWED_IPS", &cfg.Aggregator.AllowedIPs)
+
+	secret("FEDCO_JWT_SECRET", &cfg.Auth.JWTSecret)
+	secret("FEDCO_AUDIT_SECRET", &cfg.Audit.Secret)
+	duration("FEDCO_JWT_TTL", &cfg.Auth.TokenTTL)
+
+	boolean("FEDCO_RECONCILE_ENABLED", &cfg.Reconcile.Enabled)
+	duration("FEDCO_RECONCILE_INTERVAL", &cfg.Reconcile.Interval)
+	duration("FEDCO_RECONCILE_MIN_AGE", &cfg.Reconcile.MinAge)
+	duration("FEDCO_RECONCILE_EXPIRE_AFTER", &cfg.Reconcile.ExpireAfter)
+	integer("FEDCO_RECONCILE_BATCH_SIZE", &cfg.Reconcile.BatchSize)
+
+	str("FEDCO_DELETE_POLICY", &cfg.Catalog.DeletePolicy)
+
+	str("FEDCO_MEDIA_STORE", &cfg.Media.Store)
+	str("FEDCO_MEDIA_DIR", &cfg.Media.Dir)
+	str("FEDCO_MEDIA_PUBLIC_URL", &cfg.Media.PublicURL)
+	integer("FEDCO_MEDIA_MAX_UPLOAD_SIZE", &cfg.Media.MaxUploadSize)
+	integer("FEDCO_MEDIA_THUMBNAIL_SIZE", &cfg.Media.ThumbnailSize)
+	str("FEDCO_S3_ENDPOINT", &cfg.Media.S3.Endpoint)
+	str("FEDCO_S3_REGION", &cfg.Media.S3.Region)
+	str("FEDCO_S3_BUCKET", &cfg.Media.S3.Bucket)
+	str("FEDCO_S3_ACCESS_KEY", &cfg.Media.S3.AccessKey)
+	secret("FEDCO_S3_SECRET_KEY", &cfg.Media.S3.SecretKey)
+	boolean("FEDCO_S3_PATH_STYLE", &cfg.Media.S3.PathStyle)
+
+	str("FEDCO_SMS_SENDER", &cfg.SMS.Sender)
+	str("FEDCO_SMS_FILE", &cfg.SMS.File)
+	str("FEDCO_WHATSAPP_SENDER", &cfg.WhatsApp.Sender)
+	str("FEDCO_WHATSAPP_FILE", &cfg.WhatsApp.File)
+
+	boolean("FEDCO_NOTIFY_ENABLED", &cfg.Notifications.Enabled)
+	str("FEDCO_NOTIFY_CHANNEL", &cfg.Notifications.Channel)
+	duration("FEDCO_NOTIFY_INTERVAL", &cfg.Notifications.Interval)
+	integer("FEDCO_NOTIFY_MAX_ATTEMPTS", &cfg.Notifications.MaxAttempts)
+	integer("FEDCO_NOTIFY_BATCH_SIZE", &cfg.Notifications.BatchSize)
+	if v, ok := lookup("FEDCO_NOTIFY_RECEIPT_TEMPLATE"); ok {
+		if cfg.Notifications.Templates == nil {
+			cfg.Notifications.Templates = make(map[string]string)
+		}
+		cfg.Notifications.Templates[TemplateVoteReceipt] = v
+	}
+
+	boolean("FEDCO_WEBHOOKS_ENABLED", &cfg.Webhooks.Enabled)
+	duration("FEDCO_WEBHOOKS_INTERVAL", &cfg.Webhooks.Interval)
+	integer("FEDCO_WEBHOOKS_MAX_ATTEMPTS", &cfg.Webhooks.MaxAttempts)
+	integer("FEDCO_WEBHOOKS_BATCH_SIZE", &cfg.Webhooks.BatchSize)
+	duration("FEDCO_WEBHOOKS_TIMEOUT", &cfg.Webhooks.Timeout)
+
+	boolean("FEDCO_RATELIMIT_ENABLED", &cfg.RateLimit.Enabled)
+	str("FEDCO_RATELIMIT_BACKEND", &cfg.RateLimit.Backend)
+	boolean("FEDCO_RATELIMIT_LIMIT_IPS", &cfg.RateLimit.LimitIPs)
+	str("FEDCO_REDIS_ADDR", &cfg.RateLimit.Redis.Addr)
+	secret("FEDCO_REDIS_PASSWORD", &cfg.RateLimit.Redis.Password)
+	integer("FEDCO_REDIS_DB", &cfg.RateLimit.Redis.DB)
+	duration("FEDCO_REDIS_TIMEOUT", &cfg.RateLimit.Redis.Timeout)
+	integer("FEDCO_RATELIMIT_PHONE_PER_HOUR", &cfg.RateLimit.Limits.PhonePerHour)
+	integer("FEDCO_RATELIMIT_IP_PER_HOUR", &cfg.RateLimit.Limits.IPPerHour)
+	integer("FEDCO_RATELIMIT_CANDIDATE_PER_HOUR", &cfg.RateLimit.Limits.CandidatePerHour)
+
+	str("FEDCO_SIMULATOR_ADDR", &cfg.Simulator.Addr)
+	duration("FEDCO_SIMULATOR_DELAY", &cfg.Simulator.Delay)
+	duration("FEDCO_SIMULATOR_JITTER", &cfg.Simulator.Jitter)
+	number("FEDCO_SIMULATOR_SUCCESS_RATIO", &cfg.Simulator.SuccessRatio)
+	number("FEDCO_SIMULATOR_FAILURE_RATIO", &cfg.Simulator.FailureRatio)
+	number("FEDCO_SIMULATOR_TIMEOUT_RATIO", &cfg.Simulator.TimeoutRatio)
+	str("FEDCO_SIMULATOR_CALLBACK_URL", &cfg.Simulator.CallbackURL)
+
+	return errors.Join(errs...)
+}
+
+// withDefaults fills empty fields of a secondary gateway config from the primary one.
+func (g GatewayConfig) withDefaults(primary GatewayConfig) GatewayConfig {
+	if g.Provider == "" {
+		g.Provider = primary.Provider
+	}
+	if g.BaseURL == "" {
+		g.BaseURL = primary.BaseURL
+	}
+	if g.MerchantID == "" {
+		g.MerchantID = primary.MerchantID
+	}
+	if g.Token == "" {
+		g.Token = primary.Token
+	}
+	if g.CallbackURL == "" {
+		g.CallbackURL = primary.CallbackURL
+	}
+	if g.StatusAction == "" {
+		g.StatusAction = primary.StatusAction
+	}
+	if g.Timeout == 0 {
+		g.Timeout = primary.Timeout
+	}
+	if !g.InsecureSkipVerify {
+		g.InsecureSkipVerify = primary.InsecureSkipVerify
+	}
+	return g
+}
+
+// Validate reports every problem with the configuration at once.
+func (cfg *Config) Validate() error {
+	var errs []error
+
+	switch cfg.Environment {
+	case "development", "staging", "production":
+	default:
+		errs = append(errs, fmt.Errorf("environment must be development, staging or production, got %q", cfg.Environment))
+	}
+
+	if _, _, err := net.SplitHostPort(cfg.Server.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("server.addr: %w", err))
+	}
+	if cfg.Database.DSN == "" {
+		errs = append(errs, errors.New("database.dsn (FEDCO_DB_DSN) is required"))
+	}
+
+	errs = append(errs, cfg.Gateway.validate("gateway")...)
+	errs = append(errs, cfg.TestGateway.validate("test_gateway")...)
+
+	if _, err := NewCallbackVerifier(cfg.Callback.Secret.Value(), cfg.Callback.AllowedIPs); err != nil {
+		errs = append(errs, fmt.Errorf("callback.allowed_ips: %w", err))
+	}
+
+	if cfg.Auth.TokenTTL <= 0 {
+		errs = append(errs, errors.New("auth.token_ttl must be positive"))
+	}
+
+	if cfg.Reconcile.Enabled {
+		if cfg.Reconcile.Interval <= 0 || cfg.Reconcile.MinAge < 0 || cfg.Reconcile.ExpireAfter <= 0 {
+			errs = append(errs, errors.New("reconcile durations must be positive"))
+		}
+		if cfg.Reconcile.BatchSize <= 0 {
+			errs = append(errs, errors.New("reconcile.batch_size must be positive"))
+		}
+	}
+
+	switch cfg.Catalog.DeletePolicy {
+	case DeleteBlock, DeleteCascade:
+	default:
+		errs = append(errs, fmt.Errorf("catalog.delete_policy must be block or cascade, got %q", cfg.Catalog.DeletePolicy))
+	}
+
+	switch cfg.Media.Store {
+	case "local":
+		if cfg.Media.Dir == "" {
+			errs = append(errs, errors.New("media.dir is required for the local media store"))
+		}
+	case "s3":
+		if cfg.Media.S3.Endpoint == "" || cfg.Media.S3.Bucket == "" || cfg.Media.S3.AccessKey == "" || cfg.Media.S3.SecretKey == "" {
+			errs = append(errs, errors.New("media.s3 endpoint, bucket, access_key and secret_key are required for the s3 media store"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("media.store must be local or s3, got %q", cfg.Media.Store))
+	}
+	if cfg.Media.MaxUploadSize <= 0 || cfg.Media.ThumbnailSize <= 0 {
+		errs = append(errs, errors.New("media.max_upload_size and media.thumbnail_size must be positive"))
+	}
+
+	errs = append(errs, cfg.SMS.validate("sms")...)
+	errs = append(errs, cfg.WhatsApp.validate("whatsapp")...)
+
+	if cfg.Notifications.Enabled {
+		switch cfg.Notifications.Channel {
+		case ChannelSMS, ChannelWhatsApp:
+		default:
+			errs = append(errs, fmt.Errorf("notifications.channel must be sms or whatsapp, got %q", cfg.Notifications.Channel))
+		}
+		if cfg.Notifications.Interval <= 0 || cfg.Notifications.MaxAttempts <= 0 || cfg.Notifications.BatchSize <= 0 {
+			errs = append(errs, errors.New("notifications interval, max_attempts and batch_size must be positive"))
+		}
+		if _, err := parseNotificationTemplates(cfg.Notifications.Templates); err != nil {
+			errs = append(errs, fmt.Errorf("notifications.templates: %w", err))
+		}
+	}
+
+	if cfg.Webhooks.Enabled {
+		if cfg.Webhooks.Interval <= 0 || cfg.Webhooks.Timeout <= 0 || cfg.Webhooks.MaxAttempts <= 0 || cfg.Webhooks.BatchSize <= 0 {
+			errs = append(errs, errors.New("webhooks interval, timeout, max_attempts and batch_size must be positive"))
+		}
+	}
+
+	if cfg.RateLimit.Enabled {
+		switch cfg.RateLimit.Backend {
+		case "memory":
+		case "redis":
+			if cfg.RateLimit.Redis.Addr == "" || cfg.RateLimit.Redis.Timeout <= 0 {
+				errs = append(errs, errors.New("rate_limit.redis addr and a positive timeout are required for the redis backend"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("rate_limit.backend must be memory or redis, got %q", cfg.RateLimit.Backend))
+		}
+		limits := cfg.RateLimit.Limits
+		if limits.PhonePerHour < 0 || limits.IPPerHour < 0 || limits.CandidatePerHour < 0 {
+			errs = append(errs, errors.New("rate_limit.limits cannot be negative; use 0 for no limit"))
+		}
+	}
+
+	if err := cfg.Simulator.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.Environment == "production" {
+		if cfg.Callback.Secret == "" {
+			errs = append(errs, errors.New("callback.secret (FEDCO_CALLBACK_SECRET) is required in production"))
+		}
+		if cfg.Aggregator.Secret == "" && len(cfg.Aggregator.AllowedIPs) == 0 {
+			errs = append(errs, errors.New("aggregator.secret (FEDCO_AGGREGATOR_SECRET) or aggregator.allowed_ips is required in production"))
+		}
+		if len(cfg.Auth.JWTSecret) < 32 {
+			errs = append(errs, errors.New("auth.jwt_secret (FEDCO_JWT_SECRET) of at least 32 characters is required in production"))
+		}
+		if len(cfg.Audit.Secret) < 32 {
+			errs = append(errs, errors.New("audit.secret (FEDCO_AUDIT_SECRET) of at least 32 characters is required in production"))
+		}
+		if cfg.Gateway.Provider == "fake" {
+			errs = append(errs, errors.New("the fake payment provider cannot be used in production"))
+		}
+		if cfg.Gateway.InsecureSkipVerify || cfg.TestGateway.InsecureSkipVerify {
+			errs = append(errs, errors.New("gateway TLS verification (insecure_skip_verify) cannot be turned off in production"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (g GatewayConfig) validate(name string) []error {
+	var errs []error
+	switch g.Provider {
+	case "fake":
+		return nil
+	case "mamlaka":
+	default:
+		return []error{fmt.Errorf("%s.provider must be mamlaka or fake, got %q", name, g.Provider)}
+	}
+
+	if g.MerchantID == "" {
+		errs = append(errs, fmt.Errorf("%s.merchant_id is required", name))
+	}
+	if g.Token == "" {
+		errs = append(errs, fmt.Errorf("%s.token is required", name))
+	}
+	if g.StatusAction == "" {
+		errs = append(errs, fmt.Errorf("%s.status_action is required", name))
+	}
+	for field, raw := range map[string]string{"base_url": g.BaseURL, "callback_url": g.CallbackURL} {
+		if u, err := url.Parse(raw); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s.%s must be an absolute URL", name, field))
+		}
+	}
+	if g.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("%s.timeout must be positive", name))
+	}
+	return errs
+}
+
+func (s SimulatorConfig) validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(s.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("simulator.addr: %w", err))
+	}
+	if s.Delay < 0 || s.Jitter < 0 {
+		errs = append(errs, errors.New("simulator.delay and simulator.jitter cannot be negative"))
+	}
+	if s.SuccessRatio < 0 || s.FailureRatio < 0 || s.TimeoutRatio < 0 || s.SuccessRatio+s.FailureRatio+s.TimeoutRatio <= 0 {
+		errs = append(errs, errors.New("simulator ratios cannot be negative and must not all be zero"))
+	}
+	if s.CallbackURL != "" {
+		if u, err := url.Parse(s.CallbackURL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, errors.New("simulator.callback_url must be an absolute URL"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewPaymentProvider builds the provider described by the gateway config.
+func (g GatewayConfig) NewPaymentProvider() PaymentProvider {
+	if g.Provider == "fake" {
+		return NewFakePaymentProvider()
+	}
+
+	provider := NewMamlakaProvider(g.MerchantID, g.Token.Value(), g.CallbackURL)
+	provider.BaseURL = g.BaseURL
+	provider.StatusAction = g.StatusAction
+	provider.Client = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: g.InsecureSkipVerify},
+		},
+		Timeout: g.Timeout,
+	}
+	return provider
+}
+
+// NewMediaStore builds the store described by the media config.
+func (m MediaConfig) NewMediaStore() MediaStore {
+	if m.Store == "s3" {
+		return &S3MediaStore{
+			Endpoint:  m.S3.Endpoint,
+			Region:    m.S3.Region,
+			Bucket:    m.S3.Bucket,
+			AccessKey: m.S3.AccessKey,
+			SecretKey: m.S3.SecretKey.Value(),
+			PathStyle: m.S3.PathStyle,
+			PublicURL: m.PublicURL,
+			Client:    &http.Client{Timeout: 30 * time.Second},
+		}
+	}
+	return &LocalMediaStore{Dir: m.Dir, PublicURL: m.PublicURL}
+}
+
+func (s SenderConfig) validate(name string) []error {
+	switch s.Sender {
+	case "log":
+	case "file":
+		if s.File == "" {
+			return []error{fmt.Errorf("%s.file is required for the file sender", name)}
+		}
+	default:
+		return []error{fmt.Errorf("%s.sender must be log or file, got %q", name, s.Sender)}
+	}
+	return nil
+}
+
+// NewSender builds the sender described by the config for the given channel.
+func (s SenderConfig) NewSender(channel string) MessageSender {
+	if s.Sender == "file" {
+		return &FileSender{Channel: channel, Path: s.File}
+	}
+	return LogSender{Channel: channel}
+}
+
+// NewRateLimiter builds the bucket store described by the rate limit config.
+func (r RateLimitConfig) NewRateLimiter() RateLimiter {
+	if r.Backend == "redis" {
+		return &RedisRateLimiter{
+			Addr:     r.Redis.Addr,
+			Password: r.Redis.Password.Value(),
+			DB:       r.Redis.DB,
+			Timeout:  r.Redis.Timeout,
+		}
+	}
+	return NewMemoryRateLimiter()
+}
+
+// String renders the config as YAML with secrets masked, for logging at startup.
+func (cfg *Config) String() string {
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Sprintf("<config: %s>", err)
+	}
+	return string(out)
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
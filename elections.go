@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	ElectionDraft    = "draft"
+	ElectionOpen     = "open"
+	ElectionClosed   = "closed"
+	ElectionArchived = "archived"
+)
+
+// electionTransitions lists the states an election may move to from each state.
+var electionTransitions = map[string][]string{
+	ElectionDraft:    {ElectionOpen, ElectionArchived},
+	ElectionOpen:     {ElectionClosed},
+	ElectionClosed:   {ElectionOpen, ElectionArchived},
+	ElectionArchived: {},
+}
+
+// errVotingClosed is returned when a vote is cast outside its election's open window.
+var errVotingClosed = errors.New("voting is not open for this election")
+
+// Election is one awards season or event. It owns its categories and only accepts votes
+// while open and inside its start/end window.
+type Election struct {
+	gorm.Model
+	Name       string     `json:"name"`
+	Status     string     `gorm:"type:varchar(16);default:draft;index" json:"status"`
+	StartsAt   *time.Time `json:"starts_at"`
+	EndsAt     *time.Time `json:"ends_at"`
+	Categories []Category `json:"categories,omitempty"`
+	// RateLimits override the configured vote limits for this election; see VoteLimits.
+	RateLimits VoteLimits `gorm:"embedded;embeddedPrefix:rate_limit_" json:"rate_limits"`
+}
+
+type NewElectionRequest struct {
+	Name     string     `json:"name" binding:"required"`
+	StartsAt *time.Time `json:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at"`
+	// AdoptUnassigned moves categories created before elections existed into this election.
+	AdoptUnassigned bool       `json:"adopt_unassigned"`
+	RateLimits      VoteLimits `json:"rate_limits"`
+}
+
+type UpdateElectionRequest struct {
+	Name       string      `json:"name"`
+	StartsAt   *time.Time  `json:"starts_at"`
+	EndsAt     *time.Time  `json:"ends_at"`
+	RateLimits *VoteLimits `json:"rate_limits"`
+}
+
+type ElectionStatusRequest struct {
+	Status string `json:"status" binding:"required"`
+}
+
+// AcceptingVotes reports whether the election is open and now falls inside its window.
+func (e *Election) AcceptingVotes(now time.Time) bool {
+	if e.Status != ElectionOpen {
+		return false
+	}
+	if e.StartsAt != nil && now.Before(*e.StartsAt) {
+		return false
+	}
+	if e.EndsAt != nil && !now.Before(*e.EndsAt) {
+		return false
+	}
+	return true
+}
+
+// CanTransition reports whether the election may move to the given status.
+func (e *Election) CanTransition(status string) bool {
+	for _, next := range electionTransitions[e.Status] {
+		if next == status {
+			return true
+		}
+	}
+	return false
+}
+
+func validateElectionWindow(startsAt, endsAt *time.Time) error {
+	if startsAt != nil && endsAt != nil && !endsAt.After(*startsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+// ElectionForCandidate returns the election a candidate belongs to, or nil for candidates
+// in categories created before elections existed.
+func (vs *VotingSystem) ElectionForCandidate(candidateID uint) (*Election, error) {
+	var category Category
+	err := vs.DB.Model(&Category{}).
+		Joins("JOIN positions ON positions.category_id = categories.id").
+		Joins("JOIN candidates ON candidates.position_id = positions.id").
+		Where("candidates.id = ?", candidateID).
+		First(&category).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("candidate not found")
+		}
+		return nil, err
+	}
+
+	if category.ElectionID == nil {
+		return nil, nil
+	}
+
+	var election Election
+	if err := vs.DB.First(&election, *category.ElectionID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load election: %w", err)
+	}
+	return &election, nil
+}
+
+// CheckVotingOpen returns errVotingClosed when the candidate's election is not accepting votes.
+// Otherwise it returns the election, which is nil for candidates outside any election.
+func (vs *VotingSystem) CheckVotingOpen(candidateID uint) (*Election, error) {
+	election, err := vs.ElectionForCandidate(candidateID)
+	if err != nil {
+		return nil, err
+	}
+	if election != nil && !election.AcceptingVotes(time.Now()) {
+		return nil, errVotingClosed
+	}
+	return election, nil
+}
+
+func (vs *VotingSystem) CreateElection(c *gin.Context) {
+	var req NewElectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateElectionWindow(req.StartsAt, req.EndsAt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	election := Election{
+		Name:       req.Name,
+		Status:     ElectionDraft,
+		StartsAt:   req.StartsAt,
+		EndsAt:     req.EndsAt,
+		RateLimits: req.RateLimits,
+	}
+
+	tx := vs.DB.Begin()
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+
+	if err := tx.Create(&election).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create election"})
+		return
+	}
+
+	var adopted int64
+	if req.AdoptUnassigned {
+		result := tx.Model(&Category{}).Where("election_id IS NULL").Update("election_id", election.ID)
+		if result.Error != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adopt existing categories"})
+			return
+		}
+		adopted = result.RowsAffected
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":            "Election created successfully",
+		"election":           election,
+		"adopted_categories": adopted,
+	})
+}
+
+func (vs *VotingSystem) GetElections(c *gin.Context) {
+	query := vs.DB.Model(&Election{}).Order("id DESC")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var elections []Election
+	if err := query.Find(&elections).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve elections"})
+		return
+	}
+
+	c.JSON(http.StatusOK, elections)
+}
+
+func (vs *VotingSystem) GetElection(c *gin.Context) {
+	election, ok := vs.findElection(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"election":        election,
+		"accepting_votes": election.AcceptingVotes(time.Now()),
+	})
+}
+
+func (vs *VotingSystem) UpdateElection(c *gin.Context) {
+	election, ok := vs.findElection(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateElectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if election.Status == ElectionArchived {
+		c.JSON(http.StatusConflict, gin.H{"error": "Archived elections cannot be changed"})
+		return
+	}
+
+	if req.Name != "" {
+		election.Name = req.Name
+	}
+	if req.StartsAt != nil {
+		election.StartsAt = req.StartsAt
+	}
+	if req.EndsAt != nil {
+		election.EndsAt = req.EndsAt
+	}
+	if req.RateLimits != nil {
+		election.RateLimits = *req.RateLimits
+	}
+
+	if err := validateElectionWindow(election.StartsAt, election.EndsAt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := vs.DB.Save(election).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update election"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Election updated successfully", "election": election})
+}
+
+// SetElectionStatus moves an election between draft, open, closed and archived.
+func (vs *VotingSystem) SetElectionStatus(c *gin.Context) {
+	election, ok := vs.findElection(c)
+	if !ok {
+		return
+	}
+
+	var req ElectionStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !election.CanTransition(req.Status) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Cannot move election from %s to %s", election.Status, req.Status)})
+		return
+	}
+
+	result := vs.DB.Model(&Election{}).
+		Where("id = ? AND status = ?", election.ID, election.Status).
+		Update("status", req.Status)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update election status"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Election status changed concurrently, please retry"})
+		return
+	}
+	election.Status = req.Status
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Election is now %s", election.Status), "election": election})
+}
+
+// findElection loads the election named by the :id route parameter, writing the error response itself.
+func (vs *VotingSystem) findElection(c *gin.Context) (*Election, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid election ID"})
+		return nil, false
+	}
+
+	var election Election
+	if err := vs.DB.First(&election, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Election not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve election"})
+		return nil, false
+	}
+
+	return &election, true
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// exportWriter is a CSV or XLSX table being streamed to the client.
+type exportWriter interface {
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func (e *csvExportWriter) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = csvSafe(exportString(value))
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExportWriter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvSafe stops spreadsheet programs from running cell text such as voter names as formulas.
+// Signed numbers, like phone numbers in E.164, are left alone.
+func csvSafe(s string) string {
+	if s == "" {
+		return s
+	}
+	switch s[0] {
+	case '=', '@', '\t', '\r':
+		return "'" + s
+	case '+', '-':
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return "'" + s
+		}
+	}
+	return s
+}
+
+// exportFilter holds the filters shared by every export. From and to are applied separately
+// with applyDateRange on the vote timestamps.
+type exportFilter struct {
+	ElectionID uint
+	CategoryID uint
+	PositionID uint
+	// Status limits votes to one status; empty means every status.
+	Status string
+}
+
+// parseExportFilter reads election_id, category_id, position_id and status. status=all
+// disables the status filter; when status is absent defaultStatus applies.
+func parseExportFilter(c *gin.Context, defaultStatus string) (exportFilter, error) {
+	filter := exportFilter{Status: defaultStatus}
+	for name, dst := range map[string]*uint{
+		"election_id": &filter.ElectionID,
+		"category_id": &filter.CategoryID,
+		"position_id": &filter.PositionID,
+	} {
+		if v := c.Query(name); v != "" {
+			id, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s", name)
+			}
+			*dst = uint(id)
+		}
+	}
+
+	if status, ok := c.GetQuery("status"); ok {
+		filter.Status = status
+		if status == "all" {
+			filter.Status = ""
+		}
+	}
+	return filter, nil
+}
+
+// catalog filters a query that joins positions and categories.
+func (f exportFilter) catalog(db *gorm.DB) *gorm.DB {
+	if f.ElectionID != 0 {
+		db = db.Where("categories.election_id = ?", f.ElectionID)
+	}
+	if f.CategoryID != 0 {
+		db = db.Where("categories.id = ?", f.CategoryID)
+	}
+	if f.PositionID != 0 {
+		db = db.Where("positions.id = ?", f.PositionID)
+	}
+	return db
+}
+
+// votes filters a query on the votes table by status.
+func (f exportFilter) votes(db *gorm.DB) *gorm.DB {
+	if f.Status != "" {
+		db = db.Where("votes.status = ?", f.Status)
+	}
+	return db
+}
+
+// streamExport runs query and writes its rows to the client as CSV or XLSX, chosen by the
+// format query parameter. Nothing is written until the query has succeeded, so query errors
+// still get a JSON response.
+func streamExport(c *gin.Context, name string, headers []string, query *gorm.DB, scan func(*sql.Rows) ([]interface{}, error)) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "xlsx" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or xlsx"})
+		return
+	}
+
+	rows, err := query.Rows()
+	if err != nil {
+		log.Printf("Failed to run %s export: %s", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export " + name})
+		return
+	}
+	defer rows.Close()
+
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().Format("20060102-150405"), format)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Cache-Control", "no-store")
+
+	var out exportWriter
+	if format == "xlsx" {
+		c.Header("Content-Type", xlsxContentType)
+		c.Status(http.StatusOK)
+		out, err = newXLSXWriter(c.Writer, strings.ReplaceAll(name, "-", " "))
+		if err != nil {
+			log.Printf("Failed to start %s export: %s", name, err)
+			return
+		}
+	} else {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Status(http.StatusOK)
+		out = &csvExportWriter{w: csv.NewWriter(c.Writer)}
+	}
+
+	header := make([]interface{}, len(headers))
+	for i, h := range headers {
+		header[i] = h
+	}
+	if err := out.WriteRow(header); err != nil {
+		log.Printf("Failed to write %s export: %s", name, err)
+		return
+	}
+
+	count := 0
+	for rows.Next() {
+		values, err := scan(rows)
+		if err != nil {
+			log.Printf("Failed to read %s export row: %s", name, err)
+			return
+		}
+		if err := out.WriteRow(values); err != nil {
+			log.Printf("Failed to write %s export: %s", name, err)
+			return
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to read %s export rows: %s", name, err)
+		return
+	}
+
+	if err := out.Close(); err != nil && err != io.ErrClosedPipe {
+		log.Printf("Failed to finish %s export: %s", name, err)
+		return
+	}
+
+	log.Printf("%s exported %d %s rows as %s", adminName(c), count, name, format)
+}
+
+// ExportCandidateTotals exports every candidate's votes, voters and amount. Only completed
+// votes count unless status says otherwise.
+func (vs *VotingSystem) ExportCandidateTotals(c *gin.Context) {
+	filter, err := parseExportFilter(c, "completed")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	votes := vs.DB.Model(&Vote{}).
+		Select("votes.candidate_id, COUNT(*) as transactions, COUNT(DISTINCT votes.voter_id) as voters, COALESCE(SUM(votes.amount), 0) as amount, COALESCE(SUM(" + legacyVoteCountExpr + "), 0) as vote_count").
+		Scopes(filter.votes).
+		Group("votes.candidate_id")
+	if votes, err = applyDateRange(c, votes, "votes.created_at"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := vs.DB.Model(&Candidate{}).
+		Select("COALESCE(elections.name, ''), categories.name, positions.name, candidates.id, candidates.name, "+
+			"COALESCE(v.vote_count, 0) as vote_count, COALESCE(v.voters, 0), COALESCE(v.transactions, 0), COALESCE(v.amount, 0)").
+		Joins("JOIN positions ON positions.id = candidates.position_id AND positions.deleted_at IS NULL").
+		Joins("JOIN categories ON categories.id = positions.category_id AND categories.deleted_at IS NULL").
+		Joins("LEFT JOIN elections ON elections.id = categories.election_id").
+		Joins("LEFT JOIN (?) v ON v.candidate_id = candidates.id", votes).
+		Scopes(filter.catalog).
+		Order("categories.id, positions.id, vote_count DESC, candidates.id")
+
+	streamExport(c, "candidate-totals",
+		[]string{"Election", "Category", "Position", "Candidate ID", "Candidate", "Votes", "Voters", "Transactions", "Amount"},
+		query,
+		func(rows *sql.Rows) ([]interface{}, error) {
+			var election, category, position, candidate string
+			var candidateID uint
+			var votes, voters, transactions, amount int64
+			err := rows.Scan(&election, &category, &position, &candidateID, &candidate, &votes, &voters, &transactions, &amount)
+			return []interface{}{election, category, position, candidateID, candidate, votes, voters, transactions, amount}, err
+		})
+}
+
+// ExportVoterContributions exports what each voter paid and bought across the filtered votes.
+func (vs *VotingSystem) ExportVoterContributions(c *gin.Context) {
+	filter, err := parseExportFilter(c, "completed")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := vs.DB.Model(&Vote{}).
+		Select("voters.id, voters.name, voters.phone, COUNT(votes.id) as transactions, "+
+			"COALESCE(SUM("+legacyVoteCountExpr+"), 0) as vote_count, COALESCE(SUM(votes.amount), 0) as amount, "+
+			"MIN(votes.created_at), MAX(votes.created_at)").
+		Joins("JOIN voters ON voters.id = votes.voter_id").
+		Joins("JOIN candidates ON candidates.id = votes.candidate_id").
+		Joins("JOIN positions ON positions.id = candidates.position_id").
+		Joins("JOIN categories ON categories.id = positions.category_id").
+		Scopes(filter.votes, filter.catalog).
+		Group("voters.id, voters.name, voters.phone").
+		Order("amount DESC, voters.id")
+	if query, err = applyDateRange(c, query, "votes.created_at"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	streamExport(c, "voter-contributions",
+		[]string{"Voter ID", "Name", "Phone", "Transactions", "Votes", "Amount", "First vote", "Last vote"},
+		query,
+		func(rows *sql.Rows) ([]interface{}, error) {
+			var voterID uint
+			var name, phone string
+			var transactions, votes, amount int64
+			var first, last time.Time
+			err := rows.Scan(&voterID, &name, &phone, &transactions, &votes, &amount, &first, &last)
+			return []interface{}{voterID, name, phone, transactions, votes, amount, first, last}, err
+		})
+}
+
+// ExportVoteLedger exports one row per vote payment, every status unless status is given.
+func (vs *VotingSystem) ExportVoteLedger(c *gin.Context) {
+	filter, err := parseExportFilter(c, "")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := vs.DB.Model(&Vote{}).
+		Select("votes.id, votes.created_at, votes.external_id, votes.status, COALESCE(voters.name, ''), COALESCE(voters.phone, ''), "+
+			"COALESCE(elections.name, ''), categories.name, positions.name, candidates.name, votes.amount, "+
+			legacyVoteCountExpr+", votes.price_per_vote, COALESCE(votes.failure_reason, '')").
+		Joins("LEFT JOIN voters ON voters.id = votes.voter_id").
+		Joins("JOIN candidates ON candidates.id = votes.candidate_id").
+		Joins("JOIN positions ON positions.id = candidates.position_id").
+		Joins("JOIN categories ON categories.id = positions.category_id").
+		Joins("LEFT JOIN elections ON elections.id = categories.election_id").
+		Scopes(filter.votes, filter.catalog).
+		Order("votes.id ASC")
+	if query, err = applyDateRange(c, query, "votes.created_at"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	streamExport(c, "vote-ledger",
+		[]string{"Vote ID", "Created", "External ID", "Status", "Voter", "Phone", "Election", "Category", "Position", "Candidate", "Amount", "Votes", "Price per vote", "Failure reason"},
+		query,
+		func(rows *sql.Rows) ([]interface{}, error) {
+			var voteID uint
+			var created time.Time
+			var externalID, status, voter, phone, election, category, position, candidate, reason string
+			var amount, votes, price int64
+			err := rows.Scan(&voteID, &created, &externalID, &status, &voter, &phone, &election, &category, &position, &candidate, &amount, &votes, &price, &reason)
+			return []interface{}{voteID, created, externalID, status, voter, phone, election, category, position, candidate, amount, votes, price, reason}, err
+		})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fedco/models"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// callbackApplies reports whether a gateway callback may still change a vote in status current.
+// Pending votes take any outcome; a completed vote can only be reversed. An expired vote can
+// still be completed, since the reconciler expires votes it could not get an answer for and the
+// gateway may yet report the payment.
+func callbackApplies(current, transactionStatus string) bool {
+	switch current {
+	case models.VotePending:
+		return true
+	case models.VoteCompleted:
+		return transactionStatus != "COMPLETED" && models.FailureStatus(transactionStatus) == models.VoteReversed
+	case models.VoteExpired:
+		return transactionStatus == "COMPLETED"
+	}
+	return false
+}
+
+// gatewayFailureReason is the FailureReason stored for a payment the gateway did not complete.
+func gatewayFailureReason(transactionStatus, report string) string {
+	if report = strings.TrimSpace(report); report != "" {
+		return report
+	}
+	return fmt.Sprintf("gateway reported %s", transactionStatus)
+}
+
+// FailureCounts breaks vote payments down by status. FailureRate is the share of payments that
+// have finished, one way or the other, without paying for votes.
+type FailureCounts struct {
+	Attempts    int64   `json:"attempts"`
+	Pending     int64   `json:"pending"`
+	Completed   int64   `json:"completed"`
+	Failed      int64   `json:"failed"`
+	Cancelled   int64   `json:"cancelled"`
+	Expired     int64   `json:"expired"`
+	Reversed    int64   `json:"reversed"`
+	FailureRate float64 `json:"failure_rate"`
+}
+
+func (f *FailureCounts) computeRate() {
+	failures := f.Failed + f.Cancelled + f.Expired + f.Reversed
+	if finished := f.Attempts - f.Pending; finished > 0 {
+		f.FailureRate = float64(failures) / float64(finished)
+	}
+}
+
+// HourlyFailureRate is one hour of vote payments, by the time they were started.
+type HourlyFailureRate struct {
+	Hour string `json:"hour"`
+	FailureCounts
+}
+
+// CandidateFailureRate is every vote payment made for one candidate.
+type CandidateFailureRate struct {
+	CandidateID   uint   `json:"candidate_id"`
+	CandidateName string `json:"candidate_name"`
+	PositionName  string `json:"position_name"`
+	FailureCounts
+}
+
+// failureCountColumns selects the FailureCounts columns over the votes table.
+var failureCountColumns = func() string {
+	columns := []string{"COUNT(*) AS attempts"}
+	statuses := append([]string{models.VotePending, models.VoteCompleted}, models.VoteFailureStatuses...)
+	for _, status := range statuses {
+		columns = append(columns, fmt.Sprintf("SUM(CASE WHEN votes.status = '%s' THEN 1 ELSE 0 END) AS %s", status, status))
+	}
+	return strings.Join(columns, ", ")
+}()
+
+// failureReportQuery starts a report over the votes matching the request's election_id,
+// category_id, position_id, from and to parameters.
+func (vs *VotingSystem) failureReportQuery(c *gin.Context) (*gorm.DB, error) {
+	filter, err := parseExportFilter(c, "")
+	if err != nil {
+		return nil, err
+	}
+
+	query := vs.DB.Model(&Vote{}).
+		Joins("JOIN candidates ON candidates.id = votes.candidate_id").
+		Joins("JOIN positions ON positions.id = candidates.position_id").
+		Joins("JOIN categories ON categories.id = positions.category_id").
+		Scopes(filter.catalog)
+	return applyDateRange(c, query, "votes.created_at")
+}
+
+// FailureRatesByHour reports payment outcomes per hour. Without a from parameter it covers the
+// last 24 hours.
+func (vs *VotingSystem) FailureRatesByHour(c *gin.Context) {
+	query, err := vs.failureReportQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("from") == "" {
+		query = query.Where("votes.created_at >= ?", time.Now().Add(-24*time.Hour))
+	}
+
+	var hours []HourlyFailureRate
+	if err := query.
+		Select("DATE_FORMAT(votes.created_at, '%Y-%m-%d %H:00') AS hour, " + failureCountColumns).
+		Group("hour").
+		Order("hour ASC").
+		Scan(&hours).Error; err != nil {
+		log.Printf("Failed to build hourly failure report: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve failure rates"})
+		return
+	}
+
+	for i := range hours {
+		hours[i].computeRate()
+	}
+	if hours == nil {
+		hours = []HourlyFailureRate{}
+	}
+
+	c.JSON(http.StatusOK, hours)
+}
+
+// FailureRatesByCandidate reports payment outcomes per candidate, highest failure rate first.
+func (vs *VotingSystem) FailureRatesByCandidate(c *gin.Context) {
+	query, err := vs.failureReportQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var candidates []CandidateFailureRate
+	if err := query.
+		Select("candidates.id AS candidate_id, candidates.name AS candidate_name, positions.name AS position_name, " + failureCountColumns).
+		Group("candidates.id, candidates.name, positions.name").
+		Scan(&candidates).Error; err != nil {
+		log.Printf("Failed to build candidate failure report: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve failure rates"})
+		return
+	}
+
+	for i := range candidates {
+		candidates[i].computeRate()
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].FailureRate != candidates[j].FailureRate {
+			return candidates[i].FailureRate > candidates[j].FailureRate
+		}
+		return candidates[i].Attempts > candidates[j].Attempts
+	})
+	if candidates == nil {
+		candidates = []CandidateFailureRate{}
+	}
+
+	c.JSON(http.StatusOK, candidates)
+}
@@ -2,7 +2,10 @@ module fedco
 
 go 1.23.1
 
-require github.com/gin-gonic/gin v1.10.0
+require (
+	github.com/gin-gonic/gin v1.10.0
+	gorm.io/gorm v1.25.12
+)
 
 require (
 	github.com/go-sql-driver/mysql v1.7.0 // indirect
@@ -18,7 +21,6 @@ require (
 	golang.org/x/sync v0.3.0 // indirect
 	golang.org/x/term v0.20.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
-	gorm.io/gorm v1.25.12 // indirect
 )
 
 require (
@@ -44,11 +46,11 @@ require (
 	github.com/ugorji/go/codec v1.2.12 // indirect
 	golang.ngrok.com/ngrok v1.10.0
 	golang.org/x/arch v0.8.0 // indirect
-	golang.org/x/crypto v0.23.0 // indirect
+	golang.org/x/crypto v0.23.0
 	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
 	golang.org/x/text v0.15.0 // indirect
 	google.golang.org/protobuf v1.34.1 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 	gorm.io/driver/mysql v1.5.7
 )
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Confirmation is a payment confirmation pasted from an M-Pesa SMS or from the gateway dashboard.
+type Confirmation struct {
+	// Receipt is the M-Pesa receipt number, e.g. QFT3XYZ12A.
+	Receipt string `json:"receipt,omitempty"`
+	Amount  int    `json:"amount"`
+	// Phone is the payer in the gateway's 254XXXXXXXXX form. Masked digits stay as "*".
+	Phone string `json:"phone,omitempty"`
+	// Account is the account reference typed by the payer, which may be a vote's external ID.
+	Account string `json:"account,omitempty"`
+	// ExternalID is set by the gateway format, which names the vote directly.
+	ExternalID string     `json:"external_id,omitempty"`
+	Time       *time.Time `json:"time,omitempty"`
+}
+
+var (
+	receiptPattern = regexp.MustCompile(`\b([A-Z0-9]{10})\s+(?i:confirmed)`)
+	amountPattern  = regexp.MustCompile(`(?i)\bKsh\.?\s?([0-9][0-9,]*(?:\.[0-9]{1,2})?)`)
+	phonePattern   = regexp.MustCompile(`(?:\+?254|\b0)[17][0-9*]{6,10}\b`)
+	timePattern    = regexp.MustCompile(`(?i)\bon\s+(\d{1,2}/\d{1,2}/\d{2,4})\s+at\s+(\d{1,2}:\d{2}(?::\d{2})?\s*[AP]M)`)
+	accountPattern = regexp.MustCompile(`(?i)\b(?:for account|account number|account no\.?)\s*:?\s*([A-Za-z0-9_-]+)`)
+	maskPattern    = regexp.MustCompile(`\*+`)
+)
+
+// M-Pesa writes dates day first.
+var confirmationTimeLayouts = []string{"2/1/06 3:04PM", "2/1/2006 3:04PM", "2/1/06 3:04:05PM", "2/1/2006 3:04:05PM"}
+
+// ParseConfirmation reads one confirmation in any of the formats below and returns what it says.
+//
+// M-Pesa to the payer:
+//
+//	QFT3XYZ12A Confirmed. Ksh100.00 sent to FEDCO for account VOTE123 on 5/6/24 at 10:15 AM New M-PESA balance is Ksh1,000.00.
+//
+// M-Pesa to the business:
+//
+//	QFT3XYZ12A Confirmed. on 5/6/24 at 10:15 AM Ksh100.00 received from JOHN DOE 254712345678. Account Number VOTE123 ...
+//
+// The gateway dashboard, as accepted by StripData:
+//
+//	{ExternalId:VOTE123, Amount:100, SecureId:QFT3XYZ12A}
+func ParseConfirmation(text string) (*Confirmation, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "{") {
+		return parseGatewayConfirmation(text)
+	}
+
+	conf := &Confirmation{}
+	if m := receiptPattern.FindStringSubmatch(text); m != nil {
+		conf.Receipt = m[1]
+	} else {
+		return nil, errors.New("no M-Pesa receipt number found")
+	}
+
+	// The first amount is the payment; later ones are balances and charges.
+	m := amountPattern.FindStringSubmatch(text)
+	if m == nil {
+		return nil, errors.New("no amount found")
+	}
+	amount, err := ParseShillings(m[1])
+	if err != nil {
+		return nil, err
+	}
+	conf.Amount = amount
+
+	if phone := phonePattern.FindString(text); phone != "" {
+		conf.Phone = confirmationPhone(phone)
+	}
+	if m := accountPattern.FindStringSubmatch(text); m != nil {
+		conf.Account = m[1]
+	}
+	if m := timePattern.FindStringSubmatch(text); m != nil {
+		value := m[1] + " " + strings.ToUpper(strings.ReplaceAll(m[2], " ", ""))
+		for _, layout := range confirmationTimeLayouts {
+			if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+				conf.Time = &t
+				break
+			}
+		}
+	}
+
+	return conf, nil
+}
+
+func parseGatewayConfirmation(text string) (*Confirmation, error) {
+	data, err := StripData(text)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &Confirmation{}
+	conf.ExternalID, _ = data["ExternalId"].(string)
+	if conf.ExternalID == "" {
+		return nil, errors.New("ExternalId is missing")
+	}
+	amount, ok := data["Amount"].(int)
+	if !ok || amount <= 0 {
+		return nil, errors.New("Amount is missing or not a positive integer")
+	}
+	conf.Amount = amount
+	conf.Receipt, _ = data["SecureId"].(string)
+	return conf, nil
+}
+
+// ParseShillings converts "1,000.00" to 1000, rounding to the nearest shilling.
+func ParseShillings(value string) (int, error) {
+	f, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", ""), 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	return int(math.Round(f)), nil
+}
+
+// confirmationPhone converts a number from a message to the gateway's 254XXXXXXXXX form,
+// keeping masked digits.
+func confirmationPhone(phone string) string {
+	if !strings.Contains(phone, "*") {
+		if normalized, err := NormalizePhone(phone); err == nil {
+			return GatewayPhone(normalized)
+		}
+	}
+	phone = strings.TrimPrefix(phone, "+")
+	if strings.HasPrefix(phone, "0") {
+		phone = "254" + phone[1:]
+	}
+	return phone
+}
+
+// masked reports whether some of the phone number's digits are hidden.
+func (c *Confirmation) masked() bool {
+	return strings.Contains(c.Phone, "*")
+}
+
+// phoneLike turns a masked number such as 2547****5678 into a LIKE pattern.
+func (c *Confirmation) phoneLike() string {
+	return maskPattern.ReplaceAllString(c.Phone, "%")
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseConfirmation(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    Confirmation
+		wantAt  string
+		wantErr bool
+	}{
+		{
+			name:   "payer message",
+			text:   "QFT3XYZ12A Confirmed. Ksh100.00 sent to FEDCO for account VOTE123 on 5/6/24 at 10:15 AM New M-PESA balance is Ksh1,000.00.",
+			want:   Confirmation{Receipt: "QFT3XYZ12A", Amount: 100, Account: "VOTE123"},
+			wantAt: "2024-06-05 10:15:00",
+		},
+		{
+			name:   "business message",
+			text:   "QFT3XYZ12A Confirmed. on 5/6/24 at 10:15 AM Ksh1,250.00 received from JOHN DOE 254712345678. Account Number VOTE123 New Utility balance is Ksh9,000.00",
+			want:   Confirmation{Receipt: "QFT3XYZ12A", Amount: 1250, Phone: "254712345678", Account: "VOTE123"},
+			wantAt: "2024-06-05 10:15:00",
+		},
+		{
+			name:   "masked local phone",
+			text:   "QFT3XYZ12A Confirmed. Ksh50.00 received from JANE 07****5678 on 12/11/2024 at 9:05:30 PM",
+			want:   Confirmation{Receipt: "QFT3XYZ12A", Amount: 50, Phone: "2547****5678"},
+			wantAt: "2024-11-12 21:05:30",
+		},
+		{
+			name: "amount rounded to the shilling",
+			text: "QFT3XYZ12A Confirmed. Ksh 99.50 sent to FEDCO",
+			want: Confirmation{Receipt: "QFT3XYZ12A", Amount: 100},
+		},
+		{
+			name: "gateway dashboard",
+			text: "  {ExternalId:VOTE-123_4, Amount:200, SecureId:QFT3XYZ12A}",
+			want: Confirmation{Receipt: "QFT3XYZ12A", Amount: 200, ExternalID: "VOTE-123_4"},
+		},
+		{name: "no receipt", text: "Confirmed. Ksh100.00 sent to FEDCO", wantErr: true},
+		{name: "no amount", text: "QFT3XYZ12A Confirmed. sent to FEDCO", wantErr: true},
+		{name: "gateway without external ID", text: "{Amount:200, SecureId:QFT3XYZ12A}", wantErr: true},
+		{name: "gateway with zero amount", text: "{ExternalId:VOTE123, Amount:0}", wantErr: true},
+		{name: "gateway with bad amount", text: "{ExternalId:VOTE123, Amount:abc}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := ParseConfirmation(tt.text)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseConfirmation() = %+v, want an error", conf)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseConfirmation() error: %s", err)
+			}
+
+			got := *conf
+			got.Time = nil
+			if got != tt.want {
+				t.Errorf("ParseConfirmation() = %+v, want %+v", got, tt.want)
+			}
+
+			switch {
+			case tt.wantAt == "" && conf.Time != nil:
+				t.Errorf("Time = %s, want none", conf.Time)
+			case tt.wantAt != "" && conf.Time == nil:
+				t.Errorf("Time missing, want %s", tt.wantAt)
+			case tt.wantAt != "" && conf.Time.Format(time.DateTime) != tt.wantAt:
+				t.Errorf("Time = %s, want %s", conf.Time.Format(time.DateTime), tt.wantAt)
+			}
+		})
+	}
+}
+
+func TestParseShillings(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"100", 100, false},
+		{"1,000.00", 1000, false},
+		{"10.49", 10, false},
+		{"10.50", 11, false},
+		{"0", 0, true},
+		{"-5", 0, true},
+		{"ten", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseShillings(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseShillings(%q) = %d, %v; want %d, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestConfirmationPhoneLike(t *testing.T) {
+	conf := Confirmation{Phone: confirmationPhone("+2547***45678")}
+	if !conf.masked() {
+		t.Fatalf("%q is not reported as masked", conf.Phone)
+	}
+	if got := conf.phoneLike(); got != "2547%45678" {
+		t.Errorf("phoneLike() = %q, want %q", got, "2547%45678")
+	}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NormalizePhone converts a Kenyan mobile number written as 07XXXXXXXX, 01XXXXXXXX,
+// 7XXXXXXXX, 254XXXXXXXXX or +254XXXXXXXXX to E.164 (+254XXXXXXXXX).
+func NormalizePhone(phone string) (string, error) {
+	digits := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '(', ')', '.':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(phone))
+	digits = strings.TrimPrefix(digits, "+")
+
+	switch {
+	case len(digits) == 12 && strings.HasPrefix(digits, "254"):
+		digits = digits[3:]
+	case len(digits) == 10 && strings.HasPrefix(digits, "0"):
+		digits = digits[1:]
+	case len(digits) == 9:
+	default:
+		return "", fmt.Errorf("invalid phone number %q", phone)
+	}
+
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return "", fmt.Errorf("invalid phone number %q", phone)
+		}
+	}
+	if digits[0] != '7' && digits[0] != '1' {
+		return "", fmt.Errorf("invalid phone number %q", phone)
+	}
+
+	return "+254" + digits, nil
+}
+
+// GatewayPhone returns an E.164 number in the 254XXXXXXXXX form the payment gateway expects.
+func GatewayPhone(e164 string) string {
+	return strings.TrimPrefix(e164, "+")
+}
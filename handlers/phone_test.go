@@ -0,0 +1,40 @@
+package handlers
+
+import "testing"
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		phone   string
+		want    string
+		wantErr bool
+	}{
+		{phone: "0712345678", want: "+254712345678"},
+		{phone: "0112345678", want: "+254112345678"},
+		{phone: "712345678", want: "+254712345678"},
+		{phone: "254712345678", want: "+254712345678"},
+		{phone: "+254712345678", want: "+254712345678"},
+		{phone: " +254 712 345 678 ", want: "+254712345678"},
+		{phone: "(0712) 345-678", want: "+254712345678"},
+		{phone: "0712.345.678", want: "+254712345678"},
+		{phone: "", wantErr: true},
+		{phone: "071234567", wantErr: true},
+		{phone: "07123456789", wantErr: true},
+		{phone: "0812345678", wantErr: true},
+		{phone: "+255712345678", wantErr: true},
+		{phone: "07123456a8", wantErr: true},
+		{phone: "+0712345678x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizePhone(tt.phone)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("NormalizePhone(%q) = %q, %v; want %q, error %v", tt.phone, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGatewayPhone(t *testing.T) {
+	if got := GatewayPhone("+254712345678"); got != "254712345678" {
+		t.Errorf("GatewayPhone() = %q, want 254712345678", got)
+	}
+}
@@ -2,14 +2,19 @@ package handlers
 
 import (
 	"errors"
+	"fedco/audit"
 	"fedco/models"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"io"
+	"log"
+	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // StripData extracts data from the given text and returns it as a map
@@ -52,76 +57,391 @@ func StripData(text string) (map[string]interface{}, error) {
 		}
 	}
 
-	fmt.Println(result)
-
 	return result, nil
 }
 
-// UpdateTransactionAmount checks if a record exists with the given ExternalId and updates the Amount field.
-func UpdateTransactionAmount(db *gorm.DB, data map[string]interface{}) error {
-	// Check for ExternalId in the data map
-	externalId, ok := data["ExternalId"].(string)
-	if !ok {
-		return fmt.Errorf("ExternalId is missing or not a string")
+// Outcomes reported for each line of a confirmation upload.
+const (
+	ConfirmationMatched     = "matched"     // the vote already agrees with the message
+	ConfirmationUpdated     = "updated"     // the vote was (or, in a dry run, would be) brought in line
+	ConfirmationUnmatched   = "unmatched"   // no vote could be found for the message
+	ConfirmationConflicting = "conflicting" // a vote was found but the message contradicts it
+	ConfirmationInvalid     = "invalid"     // the line could not be parsed
+	ConfirmationError       = "error"
+)
+
+const (
+	// confirmationWindow is how far a message's timestamp may be from when the payment was
+	// requested for the two to be matched by phone and amount.
+	confirmationWindow = 30 * time.Minute
+	// maxConfirmationUpload caps the size of a pasted or uploaded batch.
+	maxConfirmationUpload = 2 << 20
+)
+
+// ConfirmationResult is what happened to one line of a confirmation upload.
+type ConfirmationResult struct {
+	Line       int                    `json:"line"`
+	Outcome    string                 `json:"outcome"`
+	Receipt    string                 `json:"receipt,omitempty"`
+	Amount     int                    `json:"amount,omitempty"`
+	ExternalID string                 `json:"external_id,omitempty"`
+	VoteID     uint                   `json:"vote_id,omitempty"`
+	Changes    map[string]interface{} `json:"changes,omitempty"`
+	Detail     string                 `json:"detail,omitempty"`
+	// Vote is the vote after an applied update.
+	Vote *models.Vote `json:"-"`
+}
+
+// ConfirmationHooks let the caller, which owns pricing and the outboxes, take part in each
+// confirmation's transaction.
+type ConfirmationHooks struct {
+	// Requote returns how many votes a vote buys once its amount is corrected to amount. An
+	// error leaves the vote alone and reports the line as conflicting.
+	Requote func(tx *gorm.DB, vote *models.Vote, amount int) (int, error)
+	// StatusChanged runs before commit when a confirmation moves a vote from oldStatus; an
+	// error rolls the confirmation back. receipt is the message's M-Pesa receipt, if any.
+	StatusChanged func(tx *gorm.DB, vote models.Vote, oldStatus, receipt string) error
+}
+
+// ConfirmationReport summarizes a confirmation upload, one result per non-empty line.
+type ConfirmationReport struct {
+	DryRun  bool                 `json:"dry_run"`
+	Counts  map[string]int       `json:"counts"`
+	Results []ConfirmationResult `json:"results"`
+}
+
+// ReconcileConfirmations matches each line of text, one confirmation message per line, to a
+// vote. A message proves the payment went through, so the vote is completed at the amount
+// actually paid and the receipt is recorded on the ledger. With dryRun nothing is written.
+// Changes are recorded in the audit log under actor.
+func ReconcileConfirmations(db *gorm.DB, text string, dryRun bool, actor string, hooks ConfirmationHooks) *ConfirmationReport {
+	report := &ConfirmationReport{DryRun: dryRun, Counts: make(map[string]int), Results: []ConfirmationResult{}}
+	receiptLines := make(map[string]int)
+	voteLines := make(map[uint]int)
+
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var result ConfirmationResult
+		conf, err := ParseConfirmation(line)
+		switch {
+		case err != nil:
+			result = ConfirmationResult{Outcome: ConfirmationInvalid, Detail: err.Error()}
+		case conf.Receipt != "" && receiptLines[conf.Receipt] != 0:
+			result = ConfirmationResult{Outcome: ConfirmationConflicting, Receipt: conf.Receipt, Amount: conf.Amount,
+				Detail: fmt.Sprintf("same receipt as line %d", receiptLines[conf.Receipt])}
+		default:
+			result = reconcileConfirmation(db, conf, dryRun, actor, hooks, voteLines)
+			if conf.Receipt != "" {
+				receiptLines[conf.Receipt] = i + 1
+			}
+			if result.Outcome == ConfirmationMatched || result.Outcome == ConfirmationUpdated {
+				voteLines[result.VoteID] = i + 1
+			}
+		}
+
+		result.Line = i + 1
+		report.Counts[result.Outcome]++
+		report.Results = append(report.Results, result)
 	}
 
-	fmt.Println(externalId)
+	return report
+}
 
-	// Check for Amount in the data map and ensure it's an integer
-	newAmount, ok := data["Amount"].(int)
-	if !ok {
-		return fmt.Errorf("Amount is missing or not an integer")
+// reconcileConfirmation applies one message. matchedVotes holds the line that already matched
+// each vote in this batch; two different messages cannot both have paid for one vote.
+func reconcileConfirmation(db *gorm.DB, conf *Confirmation, dryRun bool, actor string, hooks ConfirmationHooks, matchedVotes map[uint]int) ConfirmationResult {
+	result := ConfirmationResult{Receipt: conf.Receipt, Amount: conf.Amount}
+	fail := func(err error) ConfirmationResult {
+		log.Printf("Failed to reconcile confirmation %s: %s", conf.Receipt, err)
+		result.Outcome = ConfirmationError
+		result.Detail = "database error"
+		return result
 	}
 
-	// Check if transaction exists
-	var vote models.Vote
-	result := db.Where("external_id = ?", externalId).First(&vote)
+	tx := db.Begin()
+	if tx.Error != nil {
+		return fail(tx.Error)
+	}
+
+	vote, conflict, err := findConfirmedVote(tx, conf)
+	if err != nil {
+		tx.Rollback()
+		return fail(err)
+	}
+	if conflict != "" {
+		tx.Rollback()
+		result.Outcome = ConfirmationConflicting
+		result.Detail = conflict
+		return result
+	}
+	if vote == nil {
+		tx.Rollback()
+		result.Outcome = ConfirmationUnmatched
+		return result
+	}
+	result.ExternalID = vote.ExternalID
+	result.VoteID = vote.ID
+	if line, ok := matchedVotes[vote.ID]; ok {
+		tx.Rollback()
+		result.Outcome = ConfirmationConflicting
+		result.Detail = fmt.Sprintf("vote was already matched by line %d", line)
+		return result
+	}
+
+	var payment models.PaymentTransaction
+	hasPayment := true
+	if err := tx.Where("external_id = ?", vote.ExternalID).First(&payment).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			tx.Rollback()
+			return fail(err)
+		}
+		hasPayment = false
+	}
 
-	fmt.Println(result)
+	conflict, err = confirmationConflict(tx, conf, vote, &payment, hasPayment)
+	if err != nil {
+		tx.Rollback()
+		return fail(err)
+	}
+	if conflict != "" {
+		tx.Rollback()
+		result.Outcome = ConfirmationConflicting
+		result.Detail = conflict
+		return result
+	}
 
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return fmt.Errorf("vote with ExternalId %s not found", externalId)
+	oldValues := map[string]interface{}{}
+	changes := map[string]interface{}{}
+	if conf.Amount != vote.Amount {
+		// Discount tiers mean the votes bought cannot be read off the base price
+		votes, err := 0, errors.New("no way to re-price votes")
+		if hooks.Requote != nil {
+			votes, err = hooks.Requote(tx, vote, conf.Amount)
+		}
+		if err != nil {
+			tx.Rollback()
+			result.Outcome = ConfirmationConflicting
+			result.Detail = fmt.Sprintf("message shows %d but the vote has %d, review manually: %s", conf.Amount, vote.Amount, err)
+			return result
 		}
-		return result.Error
+		oldValues["amount"], oldValues["vote_count"] = vote.Amount, vote.VoteCount
+		vote.Amount = conf.Amount
+		vote.VoteCount = votes
+		changes["amount"], changes["vote_count"] = vote.Amount, vote.VoteCount
+	}
+	oldStatus := vote.Status
+	statusChanged := vote.Status != models.VoteCompleted
+	if statusChanged {
+		oldValues["status"] = vote.Status
+		vote.Status = models.VoteCompleted
+		vote.FailureReason = ""
+		changes["status"] = vote.Status
+	}
+	voteChanged := len(changes) > 0
+	recordReceipt := hasPayment && conf.Receipt != "" && payment.Receipt == ""
+	if recordReceipt {
+		changes["receipt"] = conf.Receipt
 	}
 
-	// Update the Amount field
-	vote.Amount = newAmount
-	updateResult := db.Model(&vote).Clauses(clause.Returning{}).Updates(vote)
-	if updateResult.Error != nil {
-		return fmt.Errorf("failed to update amount: %v", updateResult.Error)
+	if len(changes) == 0 {
+		tx.Rollback()
+		result.Outcome = ConfirmationMatched
+		return result
+	}
+	result.Outcome = ConfirmationUpdated
+	result.Changes = changes
+	if dryRun {
+		tx.Rollback()
+		return result
 	}
 
-	return nil
+	if voteChanged {
+		updates := map[string]interface{}{"amount": vote.Amount, "vote_count": vote.VoteCount, "status": vote.Status, "failure_reason": ""}
+		if err := tx.Model(vote).Updates(updates).Error; err != nil {
+			tx.Rollback()
+			return fail(err)
+		}
+
+		action := audit.ActionAmountChanged
+		if statusChanged {
+			action = audit.ActionStatusChanged
+		}
+		if err := audit.Record(tx, audit.Vote(vote, action, audit.SourceAdmin, actor, oldValues, changes)); err != nil {
+			tx.Rollback()
+			return fail(err)
+		}
+	}
+	if statusChanged && hooks.StatusChanged != nil {
+		if err := hooks.StatusChanged(tx, *vote, oldStatus, conf.Receipt); err != nil {
+			tx.Rollback()
+			return fail(err)
+		}
+	}
+
+	if hasPayment {
+		paymentUpdates := map[string]interface{}{"status": models.TransactionCompleted}
+		if recordReceipt {
+			paymentUpdates["receipt"] = conf.Receipt
+		}
+		if err := tx.Model(&payment).Updates(paymentUpdates).Error; err != nil {
+			tx.Rollback()
+			return fail(err)
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return fail(err)
+	}
+	result.Vote = vote
+	return result
 }
 
-// POST request to update transaction based on the provided text
+// findConfirmedVote finds and locks the vote a confirmation paid for: by external ID, by a
+// receipt already on the ledger, by account reference, or else by phone, amount and time.
+// It returns a conflict instead when several payments fit the message equally well.
+func findConfirmedVote(tx *gorm.DB, conf *Confirmation) (*models.Vote, string, error) {
+	externalID := conf.ExternalID
+
+	if externalID == "" && conf.Receipt != "" {
+		var payment models.PaymentTransaction
+		err := tx.Where("receipt = ?", conf.Receipt).First(&payment).Error
+		if err == nil {
+			externalID = payment.ExternalID
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", err
+		}
+	}
+
+	if externalID == "" && conf.Account != "" {
+		var count int64
+		if err := tx.Model(&models.Vote{}).Where("external_id = ?", conf.Account).Count(&count).Error; err != nil {
+			return nil, "", err
+		}
+		if count > 0 {
+			externalID = conf.Account
+		}
+	}
 
-func UpdateVoteHandler(db *gorm.DB, c *gin.Context) {
+	if externalID == "" && conf.Phone != "" {
+		query := tx.Where("amount = ? AND (receipt = '' OR receipt IS NULL)", conf.Amount)
+		if conf.masked() {
+			query = query.Where("phone LIKE ?", conf.phoneLike())
+		} else {
+			query = query.Where("phone = ?", conf.Phone)
+		}
+		if conf.Time != nil {
+			query = query.Where("initiated_at BETWEEN ? AND ?", conf.Time.Add(-confirmationWindow), conf.Time.Add(confirmationWindow))
+		}
+
+		var payments []models.PaymentTransaction
+		if err := query.Limit(2).Find(&payments).Error; err != nil {
+			return nil, "", err
+		}
+		switch len(payments) {
+		case 0:
+		case 1:
+			externalID = payments[0].ExternalID
+		default:
+			return nil, fmt.Sprintf("more than one payment of %d from %s", conf.Amount, conf.Phone), nil
+		}
+	}
+
+	if externalID == "" {
+		return nil, "", nil
+	}
+
+	var vote models.Vote
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("external_id = ?", externalID).First(&vote).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &vote, "", nil
+}
+
+// confirmationConflict explains why a confirmation cannot be applied to the vote it matched.
+func confirmationConflict(tx *gorm.DB, conf *Confirmation, vote *models.Vote, payment *models.PaymentTransaction, hasPayment bool) (string, error) {
+	if vote.Status == models.VoteReversed {
+		return "the gateway reversed this payment", nil
+	}
+
+	if hasPayment {
+		if conf.Receipt != "" && payment.Receipt != "" && payment.Receipt != conf.Receipt {
+			return fmt.Sprintf("payment was already confirmed by receipt %s", payment.Receipt), nil
+		}
+		if conf.Phone != "" && !conf.masked() && payment.Phone != "" && payment.Phone != conf.Phone {
+			return fmt.Sprintf("message is from %s but the payment was requested from %s", conf.Phone, payment.Phone), nil
+		}
+	}
+
+	if conf.Receipt != "" {
+		var other models.PaymentTransaction
+		err := tx.Where("receipt = ? AND external_id <> ?", conf.Receipt, vote.ExternalID).First(&other).Error
+		if err == nil {
+			return fmt.Sprintf("receipt is already recorded for payment %s", other.ExternalID), nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", err
+		}
+	}
+
+	return "", nil
+}
+
+// POST request to reconcile votes with pasted or uploaded confirmation messages. The messages
+// come as "text" in a JSON body or as a "file" upload, one per line; dry_run reports without
+// changing anything.
+func UpdateVoteHandler(db *gorm.DB, c *gin.Context, actor string, hooks ConfirmationHooks) *ConfirmationReport {
 	var requestBody struct {
-		Text string `json:"text"`
+		Text   string `json:"text" form:"text"`
+		DryRun bool   `json:"dry_run" form:"dry_run"`
 	}
 
-	// Bind the JSON body to the requestBody struct
-	if err := c.ShouldBindJSON(&requestBody); err != nil {
+	// Bind the JSON or form body to the requestBody struct
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxConfirmationUpload)
+	if err := c.ShouldBind(&requestBody); err != nil {
 		c.JSON(400, gin.H{"error": "Invalid request body"})
-		return
+		return nil
+	}
+	if dryRun, err := strconv.ParseBool(c.Query("dry_run")); err == nil {
+		requestBody.DryRun = dryRun
 	}
 
-	// Extract data from the provided text
-	data, err := StripData(requestBody.Text)
-	if err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
+	if header, err := c.FormFile("file"); err == nil {
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Failed to read uploaded file"})
+			return nil
+		}
+		defer file.Close()
+		data, err := io.ReadAll(io.LimitReader(file, maxConfirmationUpload))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Failed to read uploaded file"})
+			return nil
+		}
+		requestBody.Text = string(data)
 	}
 
-	// Update the database
-	err = UpdateTransactionAmount(db, data)
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
+	if strings.TrimSpace(requestBody.Text) == "" {
+		c.JSON(400, gin.H{"error": "No confirmation messages given"})
+		return nil
 	}
 
-	c.JSON(200, gin.H{"message": "Transaction amount updated successfully"})
+	report := ReconcileConfirmations(db, requestBody.Text, requestBody.DryRun, actor, hooks)
+	log.Printf("%s reconciled %d confirmation messages (dry run %t): %v", actor, len(report.Results), report.DryRun, report.Counts)
+
+	c.JSON(200, gin.H{
+		"message": fmt.Sprintf("Processed %d confirmation messages", len(report.Results)),
+		"dry_run": report.DryRun,
+		"counts":  report.Counts,
+		"results": report.Results,
+	})
+	return report
 }
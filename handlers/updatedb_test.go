@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStripData(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "dashboard row",
+			text: "Payment {ExternalId:FEDCO_12-ab, Amount:100, NetAmount:97, SecureId:QFT3XYZ12A} received",
+			want: map[string]interface{}{"ExternalId": "FEDCO_12-ab", "Amount": 100, "NetAmount": 97, "SecureId": "QFT3XYZ12A"},
+		},
+		{
+			name: "empty braces",
+			text: "{}",
+			want: map[string]interface{}{},
+		},
+		{name: "no opening brace", text: "ExternalId:FEDCO1, Amount:100", wantErr: true},
+		{name: "no closing brace", text: "{ExternalId:FEDCO1, Amount:100", wantErr: true},
+		{name: "non-numeric amount", text: "{Amount:KES100}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := StripData(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("StripData() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StripData() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
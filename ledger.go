@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fedco/handlers"
+	"fedco/models"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type PaymentTransaction = models.PaymentTransaction
+
+// LedgerProvider wraps a PaymentProvider and stores every gateway request and response
+// as a PaymentTransaction before handing the result back to the caller.
+type LedgerProvider struct {
+	PaymentProvider
+	DB *gorm.DB
+}
+
+func NewLedgerProvider(db *gorm.DB, provider PaymentProvider) *LedgerProvider {
+	return &LedgerProvider{PaymentProvider: provider, DB: db}
+}
+
+func (l *LedgerProvider) InitiatePayment(ctx context.Context, req PaymentRequest) (*PaymentResult, error) {
+	initiatedAt := time.Now()
+	result, err := l.PaymentProvider.InitiatePayment(ctx, req)
+
+	entry := PaymentTransaction{
+		ExternalID:  req.ExternalID,
+		Phone:       req.Phone,
+		Amount:      req.Amount,
+		Currency:    req.Currency,
+		Status:      models.TransactionInitiated,
+		InitiatedAt: initiatedAt,
+	}
+	if result != nil {
+		entry.HTTPStatus = result.HTTPStatus
+		entry.RequestPayload = string(result.RequestPayload)
+		entry.ResponseBody = string(result.ResponseBody)
+	}
+	if err != nil {
+		entry.Status = models.TransactionRejected
+		entry.Error = err.Error()
+	}
+
+	if dbErr := l.DB.Create(&entry).Error; dbErr != nil {
+		log.Printf("Failed to record payment transaction %s: %s", req.ExternalID, dbErr)
+	}
+
+	return result, err
+}
+
+// recordCallbackOnLedger copies the gateway's callback fields onto the matching ledger entry.
+func recordCallbackOnLedger(tx *gorm.DB, callback *MpesaCallback, body []byte, status string) error {
+	now := time.Now()
+	result := tx.Model(&PaymentTransaction{}).
+		Where("external_id = ?", callback.ExternalId).
+		Updates(map[string]interface{}{
+			"status":             status,
+			"callback_at":        &now,
+			"callback_payload":   string(body),
+			"transaction_status": callback.TransactionStatus,
+			"transaction_report": callback.TransactionReport,
+			"net_amount":         callback.NetAmount,
+			"secure_id":          callback.SecureId,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to update payment transaction: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		log.Printf("No payment transaction recorded for %s", callback.ExternalId)
+	}
+	return nil
+}
+
+// parseDateParam accepts either a date (2006-01-02) or an RFC3339 timestamp.
+// A bare "to" date is taken to mean the end of that day.
+func parseDateParam(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, errors.New("dates must be YYYY-MM-DD or RFC3339")
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+// applyDateRange filters query on column by the request's "from" and "to" parameters.
+func applyDateRange(c *gin.Context, query *gorm.DB, column string) (*gorm.DB, error) {
+	if from := c.Query("from"); from != "" {
+		t, err := parseDateParam(from, false)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(column+" >= ?", t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseDateParam(to, true)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(column+" <= ?", t)
+	}
+	return query, nil
+}
+
+// GetPaymentTransactions lists ledger entries filtered by phone, status and date.
+func (vs *VotingSystem) GetPaymentTransactions(c *gin.Context) {
+	query := vs.DB.Model(&PaymentTransaction{})
+
+	if phone := c.Query("phone"); phone != "" {
+		if normalized, err := handlers.NormalizePhone(phone); err == nil {
+			phone = handlers.GatewayPhone(normalized)
+		}
+		query = query.Where("phone = ?", phone)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	query, err := applyDateRange(c, query, "initiated_at")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count transactions"})
+		return
+	}
+
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+	offset, _ := strconv.Atoi(c.Query("offset"))
+
+	var transactions []PaymentTransaction
+	if err := query.Order("id DESC").Limit(limit).Offset(offset).Find(&transactions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":        total,
+		"transactions": transactions,
+	})
+}
+
+// GetPaymentTransaction returns a single ledger entry with the vote it paid for.
+func (vs *VotingSystem) GetPaymentTransaction(c *gin.Context) {
+	externalID := c.Param("external_id")
+
+	var transaction PaymentTransaction
+	if err := vs.DB.Where("external_id = ?", externalID).First(&transaction).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve transaction"})
+		return
+	}
+
+	var callbacks []PaymentCallback
+	vs.DB.Where("external_id = ?", externalID).Order("id ASC").Find(&callbacks)
+
+	var vote *Vote
+	var v Vote
+	if err := vs.DB.Unscoped().Where("external_id = ?", externalID).First(&v).Error; err == nil {
+		vote = &v
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transaction": transaction,
+		"vote":        vote,
+		"callbacks":   callbacks,
+	})
+}
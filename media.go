@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif" // registered for image.Decode
+	"image/jpeg"
+	_ "image/png" // registered for image.Decode
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// localMediaRoute is where the local media store's files are served from.
+const localMediaRoute = "/media"
+
+// maxPhotoPixels rejects images that are small on disk but huge once decoded.
+const maxPhotoPixels = 40_000_000
+
+// MediaStore keeps uploaded files and returns the public URL they are served from.
+type MediaStore interface {
+	Put(ctx context.Context, key, contentType string, data []byte) (string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalMediaStore writes files under Dir. main serves Dir at localMediaRoute.
+type LocalMediaStore struct {
+	Dir string
+	// PublicURL prefixes keys in returned URLs, e.g. "/media" or "https://cdn.example.com/media".
+	PublicURL string
+}
+
+func (s *LocalMediaStore) Put(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	dst := filepath.Join(s.Dir, filepath.FromSlash(path.Clean("/"+key)))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(dst, data, 0o644); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(s.PublicURL, "/") + "/" + key, nil
+}
+
+func (s *LocalMediaStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(filepath.Join(s.Dir, filepath.FromSlash(path.Clean("/"+key))))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// S3MediaStore stores files in an S3-compatible bucket (AWS S3, MinIO) using SigV4-signed requests.
+type S3MediaStore struct {
+	Endpoint  string // e.g. "https://s3.eu-west-1.amazonaws.com" or "http://localhost:9000"
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses objects as endpoint/bucket/key, which MinIO needs. Otherwise the
+	// bucket becomes part of the host name.
+	PathStyle bool
+	// PublicURL prefixes keys in returned URLs. When empty the object URL itself is returned.
+	PublicURL string
+	Client    *http.Client
+}
+
+func (s *S3MediaStore) objectURL(key string) (*url.URL, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if s.PathStyle {
+		u.Path = "/" + s.Bucket + "/" + key
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	return u, nil
+}
+
+func (s *S3MediaStore) Put(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if err := s.do(req, data); err != nil {
+		return "", err
+	}
+
+	if s.PublicURL != "" {
+		return strings.TrimRight(s.PublicURL, "/") + "/" + key, nil
+	}
+	return u.String(), nil
+}
+
+func (s *S3MediaStore) Delete(ctx context.Context, key string) error {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req, nil)
+}
+
+func (s *S3MediaStore) do(req *http.Request, payload []byte) error {
+	s.sign(req, payload, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3 %s %s returned HTTP %d: %s", req.Method, req.URL.Path, resp.StatusCode, body)
+	}
+	return nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req.
+func (s *S3MediaStore) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	values := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		headers = []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+		values["content-type"] = ct
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(values[h]) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// s3EscapePath URI-encodes each segment of an object path the way SigV4 expects.
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(segment), "+", "%2B")
+	}
+	return strings.Join(segments, "/")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Thumbnail scales img down so neither side exceeds size, averaging the source pixels behind
+// each thumbnail pixel. Images already small enough are returned as they are.
+func Thumbnail(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for ty := 0; ty < th; ty++ {
+		y0 := bounds.Min.Y + ty*h/th
+		y1 := bounds.Min.Y + (ty+1)*h/th
+		for tx := 0; tx < tw; tx++ {
+			x0 := bounds.Min.X + tx*w/tw
+			x1 := bounds.Min.X + (tx+1)*w/tw
+
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					pr, pg, pb, pa := img.At(x, y).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+
+			i := thumb.PixOffset(tx, ty)
+			thumb.Pix[i+0] = uint8(r / n >> 8)
+			thumb.Pix[i+1] = uint8(g / n >> 8)
+			thumb.Pix[i+2] = uint8(b / n >> 8)
+			thumb.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return thumb
+}
+
+// PhotoUploads is where candidate photos go and how large they may be.
+type PhotoUploads struct {
+	Store         MediaStore
+	MaxSize       int64
+	ThumbnailSize int
+}
+
+// UploadCandidatePhoto accepts a multipart "photo" file, stores it with a JPEG thumbnail and
+// points the candidate at both. The previous photo is removed once the candidate is updated.
+func (vs *VotingSystem) UploadCandidatePhoto(c *gin.Context) {
+	if vs.Photos == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Photo uploads are not configured"})
+		return
+	}
+
+	var candidate Candidate
+	if !vs.findRecord(c, &candidate, "Candidate") {
+		return
+	}
+
+	// Leave room for the multipart framing around the file itself.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, vs.Photos.MaxSize+64<<10)
+	file, _, err := c.Request.FormFile("photo")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A photo file is required and must be at most " + fmt.Sprint(vs.Photos.MaxSize) + " bytes"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, vs.Photos.MaxSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read photo"})
+		return
+	}
+	if int64(len(data)) > vs.Photos.MaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Photo is too large"})
+		return
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Photo must be a JPEG, PNG or GIF image"})
+		return
+	}
+	if config.Width*config.Height > maxPhotoPixels {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Photo dimensions are too large"})
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Photo could not be decoded"})
+		return
+	}
+
+	var thumb bytes.Buffer
+	if err := jpeg.Encode(&thumb, Thumbnail(img, vs.Photos.ThumbnailSize), &jpeg.Options{Quality: 85}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create thumbnail"})
+		return
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store photo"})
+		return
+	}
+	base := fmt.Sprintf("candidates/%d/%s", candidate.ID, hex.EncodeToString(suffix))
+	ext := "." + format
+	if format == "jpeg" {
+		ext = ".jpg"
+	}
+	photoKey, thumbKey := base+ext, base+"_thumb.jpg"
+
+	ctx := c.Request.Context()
+	photoURL, err := vs.Photos.Store.Put(ctx, photoKey, "image/"+format, data)
+	if err != nil {
+		log.Printf("Failed to store photo for candidate %d: %s", candidate.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to store photo"})
+		return
+	}
+	thumbURL, err := vs.Photos.Store.Put(ctx, thumbKey, "image/jpeg", thumb.Bytes())
+	if err != nil {
+		log.Printf("Failed to store thumbnail for candidate %d: %s", candidate.ID, err)
+		vs.Photos.Store.Delete(ctx, photoKey)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to store photo"})
+		return
+	}
+
+	oldKeys := []string{candidate.PhotoKey, candidate.ThumbnailKey}
+	if err := vs.DB.Model(&candidate).Updates(map[string]interface{}{
+		"photo_url":     photoURL,
+		"photo_key":     photoKey,
+		"thumbnail_url": thumbURL,
+		"thumbnail_key": thumbKey,
+	}).Error; err != nil {
+		vs.Photos.Store.Delete(ctx, photoKey)
+		vs.Photos.Store.Delete(ctx, thumbKey)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update candidate"})
+		return
+	}
+
+	candidate.PhotoURL, candidate.PhotoKey = photoURL, photoKey
+	candidate.ThumbnailURL, candidate.ThumbnailKey = thumbURL, thumbKey
+
+	for _, key := range oldKeys {
+		if key == "" {
+			continue
+		}
+		if err := vs.Photos.Store.Delete(ctx, key); err != nil {
+			log.Printf("Failed to remove old media %s: %s", key, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Photo uploaded successfully", "candidate": candidateResponse(candidate)})
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PaymentTransaction is the ledger entry for one payment sent to the gateway, linked to Vote.ExternalID.
+type PaymentTransaction struct {
+	gorm.Model
+	ExternalID        string     `gorm:"uniqueIndex;type:varchar(100);not null" json:"external_id"`
+	Phone             string     `gorm:"index;type:varchar(32)" json:"phone"`
+	Amount            int        `json:"amount"`
+	Currency          string     `gorm:"type:varchar(8)" json:"currency"`
+	Status            string     `gorm:"index;type:varchar(32)" json:"status"`
+	HTTPStatus        int        `json:"http_status"`
+	RequestPayload    string     `gorm:"type:text" json:"request_payload"`
+	ResponseBody      string     `gorm:"type:text" json:"response_body"`
+	Error             string     `gorm:"type:text" json:"error,omitempty"`
+	InitiatedAt       time.Time  `gorm:"index" json:"initiated_at"`
+	CallbackAt        *time.Time `json:"callback_at"`
+	CallbackPayload   string     `gorm:"type:text" json:"callback_payload"`
+	TransactionStatus string     `json:"transaction_status"`
+	TransactionReport string     `json:"transaction_report"`
+	NetAmount         string     `json:"net_amount"`
+	SecureId          string     `gorm:"type:varchar(100)" json:"secure_id"`
+	// Receipt is the M-Pesa receipt number, recorded when a confirmation message is reconciled.
+	Receipt string `gorm:"index;type:varchar(32)" json:"receipt,omitempty"`
+}
+
+// Ledger statuses for a PaymentTransaction.
+const (
+	TransactionInitiated = "initiated"
+	TransactionRejected  = "rejected"
+	TransactionCompleted = "completed"
+	TransactionFailed    = "failed"
+	TransactionExpired   = "expired"
+	TransactionCancelled = "cancelled"
+	TransactionReversed  = "reversed"
+)
@@ -1,12 +1,53 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Vote struct {
 	gorm.Model
-	VoterID     uint   `json:"voter_id"`
-	CandidateID uint   `gorm:"index" json:"candidate_id"`
-	ExternalID  string `gorm:"uniqueIndex;type:varchar(100);not null"`
-	Status      string `json:"status"`
-	Amount      int    `json:"amount"`
+	VoterID      uint   `json:"voter_id"`
+	CandidateID  uint   `gorm:"index" json:"candidate_id"`
+	ExternalID   string `gorm:"uniqueIndex;type:varchar(100);not null"`
+	Status       string `gorm:"index;type:varchar(32)" json:"status"`
+	Amount       int    `json:"amount"`
+	VoteCount    int    `json:"vote_count"`     // votes bought by this payment
+	PricePerVote int    `json:"price_per_vote"` // price in force when the vote was cast, 0 for votes cast before pricing
+	// FailureReason is the gateway's explanation for a failed, cancelled, expired or reversed payment.
+	FailureReason string `gorm:"type:text" json:"failure_reason,omitempty"`
+	// LastCheckedAt, NextCheckAt and Checks track the reconciler's gateway lookups for a vote
+	// that stays pending, so it is asked about less and less often.
+	LastCheckedAt *time.Time `json:"last_checked_at,omitempty"`
+	NextCheckAt   *time.Time `gorm:"index" json:"-"`
+	Checks        int        `json:"-"`
+}
+
+// Vote statuses. Only completed votes count towards results; the failure statuses are kept so
+// support can see what happened to a payment.
+const (
+	VotePending   = "pending"
+	VoteCompleted = "completed"
+	VoteFailed    = "failed"
+	VoteCancelled = "cancelled"
+	VoteExpired   = "expired"
+	VoteReversed  = "reversed"
+)
+
+// VoteFailureStatuses lists the statuses of payments that did not (or no longer) pay for votes.
+var VoteFailureStatuses = []string{VoteFailed, VoteCancelled, VoteExpired, VoteReversed}
+
+// FailureStatus maps a gateway transaction status other than COMPLETED to a vote status.
+func FailureStatus(transactionStatus string) string {
+	switch strings.ToUpper(transactionStatus) {
+	case "CANCELLED", "CANCELED":
+		return VoteCancelled
+	case "EXPIRED", "TIMEOUT":
+		return VoteExpired
+	case "REVERSED":
+		return VoteReversed
+	}
+	return VoteFailed
 }
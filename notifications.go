@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fedco/models"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Channels a notification can be sent on.
+const (
+	ChannelSMS      = "sms"
+	ChannelWhatsApp = "whatsapp"
+)
+
+// MessageSender delivers outgoing text messages on one channel, such as SMS or WhatsApp.
+type MessageSender interface {
+	Send(ctx context.Context, to, message string) error
+}
+
+// LogSender writes messages to the log instead of sending them.
+type LogSender struct {
+	Channel string
+}
+
+func (s LogSender) Send(ctx context.Context, to, message string) error {
+	log.Printf("%s to %s: %s", strings.ToUpper(s.Channel), to, message)
+	return nil
+}
+
+// FileSender appends messages to a file, one JSON object per line, so tests can read back
+// what would have been sent.
+type FileSender struct {
+	Channel string
+	Path    string
+
+	mu sync.Mutex
+}
+
+type sentMessage struct {
+	Time    time.Time `json:"time"`
+	Channel string    `json:"channel"`
+	To      string    `json:"to"`
+	Message string    `json:"message"`
+}
+
+func (s *FileSender) Send(ctx context.Context, to, message string) error {
+	line, err := json.Marshal(sentMessage{Time: time.Now(), Channel: s.Channel, To: to, Message: message})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s file: %w", s.Channel, err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s file: %w", s.Channel, err)
+	}
+	return f.Close()
+}
+
+// Notification statuses.
+const (
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	// NotificationFailed means every attempt failed and the worker gave up.
+	NotificationFailed = "failed"
+)
+
+// Notification is one message in the outbox. It is written in the same transaction as the
+// change it reports and delivered later by the NotificationWorker, so a slow or failing
+// transport never holds up a payment callback.
+type Notification struct {
+	gorm.Model
+	// DedupKey stops the same message being queued twice, e.g. "vote_receipt:42".
+	DedupKey      string     `gorm:"uniqueIndex;size:100" json:"dedup_key"`
+	Channel       string     `gorm:"size:16" json:"channel"`
+	Template      string     `gorm:"size:64" json:"template"`
+	Recipient     string     `gorm:"index;size:32" json:"recipient"`
+	Message       string     `gorm:"type:text" json:"message"`
+	VoteID        *uint      `gorm:"index" json:"vote_id,omitempty"`
+	Status        string     `gorm:"index;size:16" json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"index" json:"next_attempt_at"`
+	LastError     string     `gorm:"type:text" json:"last_error,omitempty"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+}
+
+// TemplateVoteReceipt is sent to the voter when a vote payment completes.
+const TemplateVoteReceipt = "vote_receipt"
+
+// notificationTemplates are the built-in templates, by name. Config may replace any of them.
+var notificationTemplates = map[string]string{
+	TemplateVoteReceipt: "Thank you for voting! {{.Votes}} votes for {{.Candidate}} (KES {{.Amount}}) have been counted. Ref: {{.Reference}}",
+}
+
+// VoteReceiptData is what the vote_receipt template can use.
+type VoteReceiptData struct {
+	Candidate string
+	Votes     int
+	Amount    int
+	// Reference is the M-Pesa receipt number when known, otherwise ExternalID.
+	Reference  string
+	ExternalID string
+}
+
+// parseNotificationTemplates builds the template set from the built-ins and overrides, and
+// renders each one against sample data so mistakes show up at startup rather than in a callback.
+func parseNotificationTemplates(overrides map[string]string) (*template.Template, error) {
+	set := template.New("notifications").Option("missingkey=error")
+	for name, text := range notificationTemplates {
+		if override, ok := overrides[name]; ok {
+			text = override
+		}
+		if _, err := set.New(name).Parse(text); err != nil {
+			return nil, err
+		}
+	}
+	for name := range overrides {
+		if _, ok := notificationTemplates[name]; !ok {
+			return nil, fmt.Errorf("unknown template %q", name)
+		}
+	}
+
+	sample := VoteReceiptData{Candidate: "Sample Nominee", Votes: 5, Amount: 50, Reference: "QFT3XYZ12A", ExternalID: "FEDCO_1"}
+	if err := set.ExecuteTemplate(&strings.Builder{}, TemplateVoteReceipt, sample); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// Notifier renders messages from templates and queues them in the outbox.
+type Notifier struct {
+	Channel   string
+	Templates *template.Template
+}
+
+func NewNotifier(cfg NotificationConfig) (*Notifier, error) {
+	templates, err := parseNotificationTemplates(cfg.Templates)
+	if err != nil {
+		return nil, err
+	}
+	return &Notifier{Channel: cfg.Channel, Templates: templates}, nil
+}
+
+// QueueVoteReceipt queues a receipt for a completed vote inside tx. receipt is the M-Pesa
+// receipt number when the caller has it. Only failing to write the outbox is an error; a vote
+// whose voter or candidate cannot be found is logged and skipped. A nil Notifier does nothing.
+func (n *Notifier) QueueVoteReceipt(tx *gorm.DB, vote Vote, receipt string) error {
+	if n == nil {
+		return nil
+	}
+
+	var voter Voter
+	if err := tx.Unscoped().First(&voter, vote.VoterID).Error; err != nil {
+		log.Printf("No receipt for vote %s: voter %d not found: %s", vote.ExternalID, vote.VoterID, err)
+		return nil
+	}
+	var candidate Candidate
+	if err := tx.Unscoped().First(&candidate, vote.CandidateID).Error; err != nil {
+		log.Printf("No receipt for vote %s: candidate %d not found: %s", vote.ExternalID, vote.CandidateID, err)
+		return nil
+	}
+
+	data := VoteReceiptData{
+		Candidate:  candidate.Name,
+		Votes:      vote.VoteCount,
+		Amount:     vote.Amount,
+		Reference:  receipt,
+		ExternalID: vote.ExternalID,
+	}
+	if vote.PricePerVote == 0 {
+		// Rows saved before pricing existed, as in legacyVoteCountExpr.
+		data.Votes = vote.Amount / defaultPricePerVote
+	}
+	if data.Reference == "" {
+		data.Reference = vote.ExternalID
+	}
+
+	var message strings.Builder
+	if err := n.Templates.ExecuteTemplate(&message, TemplateVoteReceipt, data); err != nil {
+		log.Printf("No receipt for vote %s: %s", vote.ExternalID, err)
+		return nil
+	}
+
+	voteID := vote.ID
+	notification := Notification{
+		DedupKey:      fmt.Sprintf("%s:%d", TemplateVoteReceipt, vote.ID),
+		Channel:       n.Channel,
+		Template:      TemplateVoteReceipt,
+		Recipient:     voter.Phone,
+		Message:       message.String(),
+		VoteID:        &voteID,
+		Status:        NotificationPending,
+		NextAttemptAt: time.Now(),
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&notification).Error
+}
+
+// voteStatusChanged fills the receipt and webhook outboxes inside the transaction that moved
+// vote from oldStatus to vote.Status, whichever path made the change, so voters and partners
+// hear about exactly what was committed. receipt is the M-Pesa receipt number when the caller
+// has it.
+func (vs *VotingSystem) voteStatusChanged(tx *gorm.DB, vote Vote, oldStatus, receipt string) error {
+	if vote.Status == models.VoteCompleted && oldStatus != models.VoteCompleted {
+		if err := vs.Notifier.QueueVoteReceipt(tx, vote, receipt); err != nil {
+			return fmt.Errorf("failed to queue receipt: %w", err)
+		}
+	}
+	if err := queueVoteWebhooks(tx, vote, oldStatus); err != nil {
+		return fmt.Errorf("failed to queue webhooks: %w", err)
+	}
+	return nil
+}
+
+// notificationLease is how long a claimed notification is hidden from other workers while it
+// is being sent.
+const notificationLease = 5 * time.Minute
+
+// NotificationWorker delivers queued notifications, retrying failures with exponential backoff
+// until MaxAttempts is reached.
+type NotificationWorker struct {
+	DB *gorm.DB
+	// Transports sends messages, by channel.
+	Transports map[string]MessageSender
+
+	Interval    time.Duration
+	MaxAttempts int
+	BatchSize   int
+}
+
+func NewNotificationWorker(db *gorm.DB, transports map[string]MessageSender) *NotificationWorker {
+	return &NotificationWorker{
+		DB:          db,
+		Transports:  transports,
+		Interval:    15 * time.Second,
+		MaxAttempts: 8,
+		BatchSize:   50,
+	}
+}
+
+// Run delivers due notifications every Interval until ctx is cancelled.
+func (w *NotificationWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.RunOnce(ctx); err != nil {
+			log.Printf("Notification run failed: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce tries one batch of due notifications and returns how many were sent.
+func (w *NotificationWorker) RunOnce(ctx context.Context) (int, error) {
+	var due []Notification
+	if err := w.DB.Where("status = ? AND next_attempt_at <= ?", NotificationPending, time.Now()).
+		Order("next_attempt_at ASC").
+		Limit(w.BatchSize).
+		Find(&due).Error; err != nil {
+		return 0, fmt.Errorf("failed to load notifications: %w", err)
+	}
+
+	sent := 0
+	for _, notification := range due {
+		if ctx.Err() != nil {
+			return sent, ctx.Err()
+		}
+		if w.deliver(ctx, notification) {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// deliver claims and sends one notification and records the outcome.
+func (w *NotificationWorker) deliver(ctx context.Context, notification Notification) bool {
+	// Claim it first, so another instance of the worker does not send it too.
+	claim := w.DB.Model(&Notification{}).
+		Where("id = ? AND status = ? AND next_attempt_at = ?", notification.ID, NotificationPending, notification.NextAttemptAt).
+		Update("next_attempt_at", time.Now().Add(notificationLease))
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		return false
+	}
+
+	err := errors.New("no transport for channel " + notification.Channel)
+	if sender, ok := w.Transports[notification.Channel]; ok {
+		sendCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err = sender.Send(sendCtx, notification.Recipient, notification.Message)
+		cancel()
+	}
+
+	attempts := notification.Attempts + 1
+	updates := map[string]interface{}{"attempts": attempts}
+	if err == nil {
+		updates["status"] = NotificationSent
+		updates["sent_at"] = time.Now()
+		updates["last_error"] = ""
+	} else {
+		updates["last_error"] = err.Error()
+		if attempts >= w.MaxAttempts {
+			updates["status"] = NotificationFailed
+			log.Printf("Giving up on notification %d to %s after %d attempts: %s", notification.ID, notification.Recipient, attempts, err)
+		} else {
+			updates["next_attempt_at"] = time.Now().Add(retryBackoff(attempts))
+			log.Printf("Notification %d to %s failed, attempt %d: %s", notification.ID, notification.Recipient, attempts, err)
+		}
+	}
+	if err := w.DB.Model(&Notification{}).Where("id = ?", notification.ID).Updates(updates).Error; err != nil {
+		log.Printf("Failed to record delivery of notification %d: %s", notification.ID, err)
+	}
+	return err == nil
+}
+
+// retryBackoff is the wait before the next try after the given number of failed attempts:
+// 30s, 1m, 2m and so on, up to an hour.
+func retryBackoff(attempts int) time.Duration {
+	if attempts > 7 {
+		return time.Hour
+	}
+	if d := 30 * time.Second << (attempts - 1); d < time.Hour {
+		return d
+	}
+	return time.Hour
+}
+
+// GetNotifications lists outbox messages, newest first. It takes status, vote_id and limit
+// parameters.
+func (vs *VotingSystem) GetNotifications(c *gin.Context) {
+	query := vs.DB.Model(&Notification{}).Order("id DESC")
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if voteID := c.Query("vote_id"); voteID != "" {
+		query = query.Where("vote_id = ?", voteID)
+	}
+
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	var notifications []Notification
+	if err := query.Limit(limit).Find(&notifications).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, notifications)
+}
+
+// RetryNotification puts a notification the worker gave up on back in the queue.
+func (vs *VotingSystem) RetryNotification(c *gin.Context) {
+	var notification Notification
+	if !vs.findRecord(c, &notification, "Notification") {
+		return
+	}
+	if notification.Status != NotificationFailed {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Notification is %s, only failed notifications can be retried", notification.Status)})
+		return
+	}
+
+	if err := vs.DB.Model(&notification).Updates(map[string]interface{}{
+		"status":          NotificationPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry notification"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification queued for retry"})
+}
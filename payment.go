@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PaymentRequest describes an STK push we want the gateway to send to a payer.
+type PaymentRequest struct {
+	ExternalID string
+	PayerName  string
+	Phone      string
+	Amount     int
+	Currency   string
+}
+
+// PaymentResult is what the gateway told us when a payment was initiated.
+type PaymentResult struct {
+	ExternalID     string
+	RequestPayload []byte
+	ResponseBody   []byte
+	HTTPStatus     int
+}
+
+// PaymentStatus is the gateway's current view of a previously initiated payment.
+type PaymentStatus struct {
+	ExternalID        string
+	TransactionStatus string
+	TransactionReport string
+	Amount            string
+}
+
+// PaymentProvider is implemented by every payment gateway the voting system can talk to.
+type PaymentProvider interface {
+	// InitiatePayment sends an STK push for the given request.
+	InitiatePayment(ctx context.Context, req PaymentRequest) (*PaymentResult, error)
+	// QueryStatus asks the gateway for the status of a payment by its external ID.
+	QueryStatus(ctx context.Context, externalID string) (*PaymentStatus, error)
+	// ParseCallback decodes the raw body the gateway posts to /mpesa-callback.
+	ParseCallback(body []byte) (*MpesaCallback, error)
+}
+
+const (
+	mamlakaDefaultBaseURL = "https://official.mam-laka.com/api/"
+	mamlakaInitiateAction = "initiate_mobile_payment"
+	// mamlakaStatusAction is not in the merchant API documentation we have; it is the default for
+	// gateway.status_action and should be checked against the gateway before relying on it.
+	mamlakaStatusAction = "transaction_status"
+)
+
+// MamlakaProvider talks to the mam-laka merchant API.
+type MamlakaProvider struct {
+	BaseURL     string
+	MerchantID  string
+	Token       string
+	CallbackURL string
+	// StatusAction is the merchant action QueryStatus posts to.
+	StatusAction string
+	Client       *http.Client
+}
+
+func NewMamlakaProvider(merchantID, token, callbackURL string) *MamlakaProvider {
+	return &MamlakaProvider{
+		BaseURL:      mamlakaDefaultBaseURL,
+		MerchantID:   merchantID,
+		Token:        token,
+		CallbackURL:  callbackURL,
+		StatusAction: mamlakaStatusAction,
+		Client:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *MamlakaProvider) InitiatePayment(ctx context.Context, req PaymentRequest) (*PaymentResult, error) {
+	currency := req.Currency
+	if currency == "" {
+		currency = "KES"
+	}
+
+	mpesaData := map[string]interface{}{
+		"impalaMerchantId": p.MerchantID,
+		"currency":         currency,
+		"amount":           req.Amount,
+		"payerPhone":       req.Phone,
+		"mobileMoneySP":    "M-Pesa",
+		"externalId":       req.ExternalID,
+		"callbackUrl":      p.CallbackURL,
+	}
+
+	jsonData, err := json.Marshal(mpesaData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal MPESA request data: %w", err)
+	}
+
+	log.Printf("MPESA Request Payload: %s", string(jsonData))
+
+	result := &PaymentResult{ExternalID: req.ExternalID, RequestPayload: jsonData}
+	status, respBody, err := p.post(ctx, mamlakaInitiateAction, jsonData)
+	result.HTTPStatus = status
+	result.ResponseBody = respBody
+	if err != nil {
+		return result, err
+	}
+
+	log.Printf("MPESA Response: %s", string(respBody))
+
+	return result, nil
+}
+
+func (p *MamlakaProvider) QueryStatus(ctx context.Context, externalID string) (*PaymentStatus, error) {
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"impalaMerchantId": p.MerchantID,
+		"externalId":       externalID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal status request: %w", err)
+	}
+
+	_, respBody, err := p.post(ctx, p.StatusAction, jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	// The status response is assumed to carry the same fields the gateway posts to our callback.
+	var callback MpesaCallback
+	if err := json.Unmarshal(respBody, &callback); err != nil {
+		return nil, fmt.Errorf("failed to decode status response: %w", err)
+	}
+
+	return &PaymentStatus{
+		ExternalID:        externalID,
+		TransactionStatus: strings.ToUpper(callback.TransactionStatus),
+		TransactionReport: callback.TransactionReport,
+		Amount:            callback.Amount,
+	}, nil
+}
+
+func (p *MamlakaProvider) ParseCallback(body []byte) (*MpesaCallback, error) {
+	return decodeMpesaCallback(body)
+}
+
+// decodeMpesaCallback decodes the callback payload shared by mam-laka and the fake provider.
+func decodeMpesaCallback(body []byte) (*MpesaCallback, error) {
+	var callback MpesaCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return nil, fmt.Errorf("invalid callback data: %w", err)
+	}
+	if callback.ExternalId == "" {
+		return nil, errors.New("callback is missing externalId")
+	}
+	// Statuses are compared in upper case everywhere, as QueryStatus reports them.
+	callback.TransactionStatus = strings.ToUpper(strings.TrimSpace(callback.TransactionStatus))
+	return &callback, nil
+}
+
+// post sends a JSON payload to the given merchant action and returns the HTTP status and body.
+func (p *MamlakaProvider) post(ctx context.Context, action string, payload []byte) (int, []byte, error) {
+	url := fmt.Sprintf("%s?resource=merchant&action=%s", p.BaseURL, action)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(payload))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create MPESA request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+p.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to send MPESA request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp.StatusCode, respBody, fmt.Errorf("gateway returned HTTP %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return resp.StatusCode, respBody, nil
+}
+
+// FakePaymentProvider keeps payments in memory so tests and staging can run without the live gateway.
+// Payments stay PENDING until Settle is called, unless AutoStatus is set.
+type FakePaymentProvider struct {
+	// AutoStatus, when non-empty, is the status every new payment is immediately settled with.
+	AutoStatus string
+
+	mu       sync.Mutex
+	requests map[string]PaymentRequest
+	statuses map[string]string
+}
+
+func NewFakePaymentProvider() *FakePaymentProvider {
+	return &FakePaymentProvider{
+		requests: make(map[string]PaymentRequest),
+		statuses: make(map[string]string),
+	}
+}
+
+func (f *FakePaymentProvider) InitiatePayment(ctx context.Context, req PaymentRequest) (*PaymentResult, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exists := f.requests[req.ExternalID]; exists {
+		return nil, fmt.Errorf("payment %s already initiated", req.ExternalID)
+	}
+	f.requests[req.ExternalID] = req
+	f.statuses[req.ExternalID] = "PENDING"
+	if f.AutoStatus != "" {
+		f.statuses[req.ExternalID] = strings.ToUpper(f.AutoStatus)
+	}
+
+	return &PaymentResult{
+		ExternalID:     req.ExternalID,
+		RequestPayload: payload,
+		ResponseBody:   []byte(`{"status":"accepted","provider":"fake"}`),
+		HTTPStatus:     http.StatusOK,
+	}, nil
+}
+
+func (f *FakePaymentProvider) QueryStatus(ctx context.Context, externalID string) (*PaymentStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	req, ok := f.requests[externalID]
+	if !ok {
+		return nil, fmt.Errorf("payment %s not found", externalID)
+	}
+
+	return &PaymentStatus{
+		ExternalID:        externalID,
+		TransactionStatus: f.statuses[externalID],
+		Amount:            fmt.Sprintf("%d", req.Amount),
+	}, nil
+}
+
+func (f *FakePaymentProvider) ParseCallback(body []byte) (*MpesaCallback, error) {
+	return decodeMpesaCallback(body)
+}
+
+// Settle sets the status QueryStatus reports for a payment, e.g. COMPLETED or FAILED.
+func (f *FakePaymentProvider) Settle(externalID, status string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.requests[externalID]; !ok {
+		return fmt.Errorf("payment %s not found", externalID)
+	}
+	f.statuses[externalID] = strings.ToUpper(status)
+	return nil
+}
+
+// Requests returns every payment initiated through the fake so far.
+func (f *FakePaymentProvider) Requests() []PaymentRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	requests := make([]PaymentRequest, 0, len(f.requests))
+	for _, req := range f.requests {
+		requests = append(requests, req)
+	}
+	return requests
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFakePaymentProvider(t *testing.T) {
+	ctx := context.Background()
+	payments := NewFakePaymentProvider()
+
+	if _, err := payments.InitiatePayment(ctx, PaymentRequest{ExternalID: "A", Amount: 20}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := payments.InitiatePayment(ctx, PaymentRequest{ExternalID: "A", Amount: 20}); err == nil {
+		t.Error("initiating the same payment twice succeeded")
+	}
+
+	status, err := payments.QueryStatus(ctx, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.TransactionStatus != "PENDING" || status.Amount != "20" {
+		t.Errorf("new payment = %+v, want PENDING for 20", status)
+	}
+
+	if err := payments.Settle("A", "completed"); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := payments.QueryStatus(ctx, "A"); status.TransactionStatus != "COMPLETED" {
+		t.Errorf("settled payment is %q, want COMPLETED", status.TransactionStatus)
+	}
+
+	if err := payments.Settle("missing", "COMPLETED"); err == nil {
+		t.Error("settling an unknown payment succeeded")
+	}
+	if _, err := payments.QueryStatus(ctx, "missing"); err == nil {
+		t.Error("querying an unknown payment succeeded")
+	}
+
+	payments.AutoStatus = "failed"
+	if _, err := payments.InitiatePayment(ctx, PaymentRequest{ExternalID: "B", Amount: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := payments.QueryStatus(ctx, "B"); status.TransactionStatus != "FAILED" {
+		t.Errorf("auto-settled payment is %q, want FAILED", status.TransactionStatus)
+	}
+}
+
+func TestMamlakaQueryStatus(t *testing.T) {
+	var gotAction string
+	var gotBody map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAction = r.URL.Query().Get("action")
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &gotBody)
+		w.Write([]byte(`{"externalId":"FEDCO-1","transactionStatus":"completed","transactionReport":"ok","amount":"50"}`))
+	}))
+	defer server.Close()
+
+	provider := NewMamlakaProvider("merchant", "token", "https://example.com/callback")
+	provider.BaseURL = server.URL + "/"
+	provider.StatusAction = "payment_status"
+
+	status, err := provider.QueryStatus(context.Background(), "FEDCO-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotAction != "payment_status" {
+		t.Errorf("queried action %q, want the configured payment_status", gotAction)
+	}
+	if gotBody["externalId"] != "FEDCO-1" || gotBody["impalaMerchantId"] != "merchant" {
+		t.Errorf("request body = %v", gotBody)
+	}
+	if status.TransactionStatus != "COMPLETED" || status.TransactionReport != "ok" || status.Amount != "50" {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestDecodeMpesaCallbackStatusCase(t *testing.T) {
+	for _, status := range []string{"completed", "Completed", " COMPLETED "} {
+		callback, err := decodeMpesaCallback([]byte(`{"externalId":"FEDCO-1","transactionStatus":"` + status + `"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if callback.TransactionStatus != "COMPLETED" {
+			t.Errorf("status %q decoded as %q, want COMPLETED", status, callback.TransactionStatus)
+		}
+		if !callbackApplies("pending", callback.TransactionStatus) {
+			t.Errorf("status %q does not apply to a pending vote", status)
+		}
+	}
+
+	if _, err := decodeMpesaCallback([]byte(`{"transactionStatus":"COMPLETED"}`)); err == nil {
+		t.Error("decoded a callback without externalId")
+	}
+}
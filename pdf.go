@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 portrait in PDF points, and the margin kept free on every side.
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+	pdfMargin     = 50.0
+)
+
+// pdfCell is one piece of text on a line, starting X points from the left edge.
+type pdfCell struct {
+	X    float64
+	Text string
+}
+
+// pdfWriter lays out simple text documents: lines of text in the two standard Helvetica
+// faces, horizontal rules and automatic page breaks. It needs no fonts or images of its own.
+type pdfWriter struct {
+	pages []*bytes.Buffer
+	y     float64
+}
+
+func newPDFWriter() *pdfWriter {
+	p := &pdfWriter{}
+	p.newPage()
+	return p
+}
+
+func (p *pdfWriter) newPage() {
+	p.pages = append(p.pages, &bytes.Buffer{})
+	p.y = pdfPageHeight - pdfMargin
+}
+
+func (p *pdfWriter) page() *bytes.Buffer {
+	return p.pages[len(p.pages)-1]
+}
+
+// ensure starts a new page unless height points still fit above the bottom margin and footer.
+func (p *pdfWriter) ensure(height float64) {
+	if p.y-height < pdfMargin+20 {
+		p.newPage()
+	}
+}
+
+// Line writes cells on one line in the given size and moves down by the line height.
+func (p *pdfWriter) Line(size float64, bold bool, cells ...pdfCell) {
+	height := size * 1.5
+	p.ensure(height)
+	p.y -= size
+	for _, cell := range cells {
+		pdfText(p.page(), cell.X, p.y, size, bold, cell.Text)
+	}
+	p.y -= height - size
+}
+
+// Space moves down by height points.
+func (p *pdfWriter) Space(height float64) {
+	p.ensure(height)
+	p.y -= height
+}
+
+// Rule draws a thin horizontal line across the text area.
+func (p *pdfWriter) Rule() {
+	p.ensure(8)
+	p.y -= 4
+	fmt.Fprintf(p.page(), "0.5 w %.2f %.2f m %.2f %.2f l S\n", pdfMargin, p.y, pdfPageWidth-pdfMargin, p.y)
+	p.y -= 4
+}
+
+// Bytes renders the document, writing footer and a page count at the bottom of every page.
+func (p *pdfWriter) Bytes(footer string) []byte {
+	var out bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-4 are the catalog, page tree and fonts; each page then takes two objects,
+	// its dictionary followed by its content stream.
+	const firstPage = 5
+	kids := make([]string, len(p.pages))
+	for i := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range p.pages {
+		content := bytes.NewBuffer(page.Bytes())
+		pdfText(content, pdfMargin, pdfMargin-10, 7, false, footer)
+		pageLabel := fmt.Sprintf("Page %d of %d", i+1, len(p.pages))
+		pdfText(content, pdfPageWidth-pdfMargin-40, pdfMargin-10, 7, false, pageLabel)
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, firstPage+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.Bytes()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.Bytes()
+}
+
+func pdfText(w *bytes.Buffer, x, y, size float64, bold bool, text string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(w, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfEscape(text))
+}
+
+// pdfEscape converts text to a WinAnsi literal string. Characters outside Latin-1 become "?".
+func pdfEscape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n' || r == '\r' || r == '\t':
+			b.WriteByte(' ')
+		case r < 0x20 || r > 0xff:
+			b.WriteByte('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	return b.String()
+}
+
+// pdfTruncate shortens text to at most n characters so it stays inside its column.
+func pdfTruncate(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-3]) + "..."
+}
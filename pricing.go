@@ -0,0 +1,486 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// defaultPricePerVote is what one vote has always cost when no VotePrice is configured.
+const defaultPricePerVote = 10
+
+// maxVotesPerPurchase bounds how many votes a single payment may buy.
+const maxVotesPerPurchase = 100000
+
+// legacyVoteCountExpr counts the votes a row bought. Rows saved before pricing existed
+// have no price recorded and keep the old amount/10 rule.
+const legacyVoteCountExpr = "CASE WHEN votes.price_per_vote > 0 THEN votes.vote_count ELSE votes.amount DIV 10 END"
+
+// VotePrice sets what votes cost. A price with a PositionID applies to that position,
+// one with only a CategoryID to every position in the category, one with only an
+// ElectionID to the whole election, and one with none of them is the default for everything else.
+type VotePrice struct {
+	gorm.Model
+	ElectionID   *uint       `gorm:"index" json:"election_id"`
+	CategoryID   *uint       `gorm:"index" json:"category_id"`
+	PositionID   *uint       `gorm:"index" json:"position_id"`
+	PricePerVote int         `json:"price_per_vote"`
+	Bundles      string      `json:"bundles"` // comma-separated vote counts that may be bought, empty allows any count
+	Tiers        []PriceTier `gorm:"foreignKey:VotePriceID" json:"tiers"`
+}
+
+// PriceTier discounts purchases of at least MinVotes votes.
+type PriceTier struct {
+	gorm.Model
+	VotePriceID     uint `gorm:"index" json:"vote_price_id"`
+	MinVotes        int  `json:"min_votes"`
+	DiscountPercent int  `json:"discount_percent"`
+}
+
+// VoteQuote is the price of a number of votes under a VotePrice.
+type VoteQuote struct {
+	Votes        int   `json:"votes"`
+	Amount       int   `json:"amount"`
+	PricePerVote int   `json:"price_per_vote"`
+	PriceID      *uint `json:"price_id"`
+}
+
+type PriceTierRequest struct {
+	MinVotes        int `json:"min_votes" binding:"required"`
+	DiscountPercent int `json:"discount_percent" binding:"required"`
+}
+
+type NewVotePriceRequest struct {
+	ElectionID   *uint              `json:"election_id"`
+	CategoryID   *uint              `json:"category_id"`
+	PositionID   *uint              `json:"position_id"`
+	PricePerVote int                `json:"price_per_vote" binding:"required"`
+	Bundles      []int              `json:"bundles"`
+	Tiers        []PriceTierRequest `json:"tiers"`
+}
+
+// BundleSizes returns the allowed vote counts, or nil when any count may be bought.
+func (p *VotePrice) BundleSizes() []int {
+	var sizes []int
+	for _, part := range strings.Split(p.Bundles, ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(part)); err == nil && n > 0 {
+			sizes = append(sizes, n)
+		}
+	}
+	sort.Ints(sizes)
+	return sizes
+}
+
+// discount returns the best discount tier reached by a purchase of votes.
+func (p *VotePrice) discount(votes int) int {
+	discount := 0
+	for _, tier := range p.Tiers {
+		if votes >= tier.MinVotes && tier.DiscountPercent > discount {
+			discount = tier.DiscountPercent
+		}
+	}
+	return discount
+}
+
+// Quote returns the price of votes under this price, applying the best discount tier reached.
+func (p *VotePrice) Quote(votes int) VoteQuote {
+	discount := p.discount(votes)
+	amount := votes * p.PricePerVote
+	if discount > 0 {
+		amount = (amount*(100-discount) + 50) / 100
+	}
+
+	quote := VoteQuote{Votes: votes, Amount: amount, PricePerVote: p.PricePerVote}
+	if p.ID != 0 {
+		id := p.ID
+		quote.PriceID = &id
+	}
+	return quote
+}
+
+// QuoteVotes prices a purchase of votes, rejecting counts that are not an allowed bundle.
+func (p *VotePrice) QuoteVotes(votes int) (VoteQuote, error) {
+	if votes <= 0 || votes > maxVotesPerPurchase {
+		return VoteQuote{}, fmt.Errorf("votes must be between 1 and %d", maxVotesPerPurchase)
+	}
+	if sizes := p.BundleSizes(); len(sizes) > 0 {
+		allowed := false
+		for _, size := range sizes {
+			if size == votes {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return VoteQuote{}, fmt.Errorf("votes must be one of %s", p.Bundles)
+		}
+	}
+	return p.Quote(votes), nil
+}
+
+// QuoteAmount works out how many votes an amount buys, rejecting amounts that match no purchase.
+// Where a discount tier makes more votes cost the same as fewer, the amount buys the most.
+func (p *VotePrice) QuoteAmount(amount int) (VoteQuote, error) {
+	if amount <= 0 {
+		return VoteQuote{}, errors.New("amount must be greater than 0")
+	}
+	noMatch := fmt.Errorf("amount %d does not match any allowed vote purchase at %d per vote", amount, p.PricePerVote)
+	// Discounts only lower the price, so nothing allowed costs more than the most votes at full price.
+	if amount > p.PricePerVote*maxVotesPerPurchase {
+		return VoteQuote{}, noMatch
+	}
+
+	if sizes := p.BundleSizes(); len(sizes) > 0 {
+		for i := len(sizes) - 1; i >= 0; i-- {
+			if quote := p.Quote(sizes[i]); quote.Amount == amount {
+				return quote, nil
+			}
+		}
+		return VoteQuote{}, noMatch
+	}
+
+	// Between tier thresholds the discount is fixed, so in each stretch the most votes the
+	// amount buys can be worked out directly instead of trying every count.
+	starts := []int{1}
+	for _, tier := range p.Tiers {
+		if tier.MinVotes > 1 && tier.MinVotes <= maxVotesPerPurchase {
+			starts = append(starts, tier.MinVotes)
+		}
+	}
+	sort.Ints(starts)
+
+	best := 0
+	for i, start := range starts {
+		end := maxVotesPerPurchase
+		if i+1 < len(starts) {
+			end = starts[i+1] - 1
+		}
+		perHundred := p.PricePerVote * (100 - p.discount(start))
+		if end < start || perHundred <= 0 {
+			continue
+		}
+		// Quote rounds votes*perHundred/100 to the nearest shilling, which is at most amount
+		// for every count up to (100*amount+49)/perHundred.
+		votes := (100*amount + 49) / perHundred
+		if votes > end {
+			votes = end
+		}
+		if votes >= start && votes > best && p.Quote(votes).Amount == amount {
+			best = votes
+		}
+	}
+	if best == 0 {
+		return VoteQuote{}, noMatch
+	}
+	return p.Quote(best), nil
+}
+
+// PriceForCandidate returns the VotePrice in force for a candidate's position, falling back to the
+// category price, the election price, the default price, and finally the historic price of 10 per vote.
+func (vs *VotingSystem) PriceForCandidate(candidateID uint) (*VotePrice, error) {
+	return priceForCandidate(vs.DB, candidateID)
+}
+
+func priceForCandidate(db *gorm.DB, candidateID uint) (*VotePrice, error) {
+	var candidate Candidate
+	if err := db.First(&candidate, candidateID).Error; err != nil {
+		return nil, errors.New("candidate not found")
+	}
+
+	var position Position
+	if err := db.First(&position, candidate.PositionID).Error; err != nil {
+		return nil, errors.New("position not found")
+	}
+
+	var category Category
+	if err := db.First(&category, position.CategoryID).Error; err != nil {
+		return nil, errors.New("category not found")
+	}
+
+	query := db.Preload("Tiers").
+		Where("position_id = ?", position.ID).
+		Or("position_id IS NULL AND category_id = ?", category.ID).
+		Or("position_id IS NULL AND category_id IS NULL AND election_id IS NULL")
+	if category.ElectionID != nil {
+		query = query.Or("position_id IS NULL AND category_id IS NULL AND election_id = ?", *category.ElectionID)
+	}
+
+	var prices []VotePrice
+	if err := query.Find(&prices).Error; err != nil {
+		return nil, fmt.Errorf("failed to load pricing: %w", err)
+	}
+
+	var best *VotePrice
+	bestRank := 0
+	for i := range prices {
+		rank := 1
+		if prices[i].ElectionID != nil {
+			rank = 2
+		}
+		if prices[i].CategoryID != nil {
+			rank = 3
+		}
+		if prices[i].PositionID != nil {
+			rank = 4
+		}
+		if rank > bestRank {
+			best, bestRank = &prices[i], rank
+		}
+	}
+
+	if best == nil {
+		return &VotePrice{PricePerVote: defaultPricePerVote}, nil
+	}
+	return best, nil
+}
+
+// requoteVote works out how many votes a vote buys once its amount is corrected to amount,
+// through the candidate's price and discount tiers. It refuses when the candidate's price per
+// vote is no longer the one the vote was bought at. Votes cast before pricing keep their count,
+// which results do not read.
+func requoteVote(db *gorm.DB, vote *Vote, amount int) (int, error) {
+	if vote.PricePerVote == 0 {
+		return vote.VoteCount, nil
+	}
+
+	price, err := priceForCandidate(db, vote.CandidateID)
+	if err != nil {
+		return 0, err
+	}
+	if price.PricePerVote != vote.PricePerVote {
+		return 0, fmt.Errorf("the price changed from %d to %d per vote since the vote was bought", vote.PricePerVote, price.PricePerVote)
+	}
+
+	quote, err := price.QuoteAmount(amount)
+	if err != nil {
+		return 0, err
+	}
+	return quote.Votes, nil
+}
+
+// QuoteVote validates what a voter asked to pay for a candidate. When votes is zero the
+// amount alone decides how many votes are bought.
+func (vs *VotingSystem) QuoteVote(candidateID uint, votes, amount int) (VoteQuote, error) {
+	price, err := vs.PriceForCandidate(candidateID)
+	if err != nil {
+		return VoteQuote{}, err
+	}
+
+	if votes == 0 {
+		return price.QuoteAmount(amount)
+	}
+
+	quote, err := price.QuoteVotes(votes)
+	if err != nil {
+		return VoteQuote{}, err
+	}
+	if amount != quote.Amount {
+		return VoteQuote{}, fmt.Errorf("amount must be %d for %d votes", quote.Amount, votes)
+	}
+	return quote, nil
+}
+
+func (vs *VotingSystem) GetVotePrices(c *gin.Context) {
+	var prices []VotePrice
+	if err := vs.DB.Preload("Tiers").Order("id ASC").Find(&prices).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve pricing"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prices)
+}
+
+// SetVotePrice creates or replaces the price for a scope (position, category, election or default).
+func (vs *VotingSystem) SetVotePrice(c *gin.Context) {
+	var req NewVotePriceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.PricePerVote <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Price per vote must be greater than 0"})
+		return
+	}
+
+	var bundles []string
+	for _, size := range req.Bundles {
+		if size <= 0 || size > maxVotesPerPurchase {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Bundle sizes must be between 1 and %d", maxVotesPerPurchase)})
+			return
+		}
+		bundles = append(bundles, strconv.Itoa(size))
+	}
+
+	for _, tier := range req.Tiers {
+		if tier.MinVotes <= 0 || tier.DiscountPercent <= 0 || tier.DiscountPercent >= 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Tiers need min_votes > 0 and a discount between 1 and 99 percent"})
+			return
+		}
+	}
+
+	scope, scopeArgs := "position_id IS NULL AND category_id IS NULL AND election_id IS NULL", []interface{}{}
+	if req.PositionID != nil {
+		var position Position
+		if err := vs.DB.First(&position, *req.PositionID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Position not found"})
+			return
+		}
+		var category Category
+		if err := vs.DB.First(&category, position.CategoryID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Category not found"})
+			return
+		}
+		req.CategoryID = &position.CategoryID
+		req.ElectionID = category.ElectionID
+		scope, scopeArgs = "position_id = ?", []interface{}{*req.PositionID}
+	} else if req.CategoryID != nil {
+		var category Category
+		if err := vs.DB.First(&category, *req.CategoryID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Category not found"})
+			return
+		}
+		req.ElectionID = category.ElectionID
+		scope, scopeArgs = "position_id IS NULL AND category_id = ?", []interface{}{*req.CategoryID}
+	} else if req.ElectionID != nil {
+		var election Election
+		if err := vs.DB.First(&election, *req.ElectionID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Election not found"})
+			return
+		}
+		scope, scopeArgs = "position_id IS NULL AND category_id IS NULL AND election_id = ?", []interface{}{*req.ElectionID}
+	}
+
+	price := VotePrice{
+		ElectionID:   req.ElectionID,
+		CategoryID:   req.CategoryID,
+		PositionID:   req.PositionID,
+		PricePerVote: req.PricePerVote,
+		Bundles:      strings.Join(bundles, ","),
+	}
+	for _, tier := range req.Tiers {
+		price.Tiers = append(price.Tiers, PriceTier{MinVotes: tier.MinVotes, DiscountPercent: tier.DiscountPercent})
+	}
+
+	tx := vs.DB.Begin()
+	if tx.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+
+	// Votes keep their own price_per_vote, so replacing a price never changes past results.
+	var existing []VotePrice
+	if err := tx.Where(scope, scopeArgs...).Find(&existing).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load existing pricing"})
+		return
+	}
+	for _, old := range existing {
+		if err := tx.Where("vote_price_id = ?", old.ID).Delete(&PriceTier{}).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replace pricing"})
+			return
+		}
+		if err := tx.Delete(&old).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replace pricing"})
+			return
+		}
+	}
+
+	if err := tx.Create(&price).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save pricing"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Pricing saved successfully", "price": price})
+}
+
+func (vs *VotingSystem) DeleteVotePrice(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid price ID"})
+		return
+	}
+
+	tx := vs.DB.Begin()
+	result := tx.Delete(&VotePrice{}, id)
+	if result.Error != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete pricing"})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price not found"})
+		return
+	}
+
+	if err := tx.Where("vote_price_id = ?", id).Delete(&PriceTier{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete pricing"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete pricing"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Pricing deleted successfully"})
+}
+
+// GetVoteQuote shows a voter what a purchase costs, plus the bundles on offer.
+func (vs *VotingSystem) GetVoteQuote(c *gin.Context) {
+	candidateID, err := strconv.ParseUint(c.Query("candidate_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid candidate ID"})
+		return
+	}
+
+	price, err := vs.PriceForCandidate(uint(candidateID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{
+		"price_per_vote": price.PricePerVote,
+		"tiers":          price.Tiers,
+	}
+
+	var bundles []VoteQuote
+	for _, size := range price.BundleSizes() {
+		bundles = append(bundles, price.Quote(size))
+	}
+	response["bundles"] = bundles
+
+	if v := c.Query("votes"); v != "" {
+		votes, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vote count"})
+			return
+		}
+		quote, err := price.QuoteVotes(votes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		response["quote"] = quote
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVotePriceBundleSizes(t *testing.T) {
+	tests := []struct {
+		bundles string
+		want    []int
+	}{
+		{"", nil},
+		{"10", []int{10}},
+		{"50, 10,100", []int{10, 50, 100}},
+		{"10,,x,-5,0,20", []int{10, 20}},
+	}
+
+	for _, tt := range tests {
+		price := VotePrice{PricePerVote: 10, Bundles: tt.bundles}
+		if got := price.BundleSizes(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("BundleSizes(%q) = %v, want %v", tt.bundles, got, tt.want)
+		}
+	}
+}
+
+func TestVotePriceQuote(t *testing.T) {
+	price := VotePrice{PricePerVote: 10, Tiers: []PriceTier{
+		{MinVotes: 100, DiscountPercent: 20},
+		{MinVotes: 10, DiscountPercent: 10},
+		{MinVotes: 50, DiscountPercent: 5},
+	}}
+	price.ID = 3
+
+	tests := []struct {
+		votes, want int
+	}{
+		{1, 10},
+		{9, 90},
+		{10, 90},
+		{49, 441},
+		{50, 450}, // the 10% tier beats the 5% one
+		{99, 891},
+		{100, 800},
+		{3, 30},
+	}
+
+	for _, tt := range tests {
+		quote := price.Quote(tt.votes)
+		if quote.Amount != tt.want || quote.Votes != tt.votes || quote.PricePerVote != 10 {
+			t.Errorf("Quote(%d) = %+v, want amount %d", tt.votes, quote, tt.want)
+		}
+		if quote.PriceID == nil || *quote.PriceID != 3 {
+			t.Errorf("Quote(%d).PriceID = %v, want 3", tt.votes, quote.PriceID)
+		}
+	}
+
+	// Discounts round to the nearest shilling.
+	odd := VotePrice{PricePerVote: 7, Tiers: []PriceTier{{MinVotes: 1, DiscountPercent: 15}}}
+	if got := odd.Quote(3).Amount; got != 18 {
+		t.Errorf("Quote(3) at 7 less 15%% = %d, want 18", got)
+	}
+	if quote := (&VotePrice{PricePerVote: 10}).Quote(1); quote.PriceID != nil {
+		t.Errorf("unsaved price quoted with PriceID %d", *quote.PriceID)
+	}
+}
+
+func TestVotePriceQuoteVotes(t *testing.T) {
+	tests := []struct {
+		name    string
+		price   VotePrice
+		votes   int
+		want    int
+		wantErr bool
+	}{
+		{"any count", VotePrice{PricePerVote: 10}, 7, 70, false},
+		{"zero", VotePrice{PricePerVote: 10}, 0, 0, true},
+		{"negative", VotePrice{PricePerVote: 10}, -1, 0, true},
+		{"over the cap", VotePrice{PricePerVote: 10}, maxVotesPerPurchase + 1, 0, true},
+		{"bundle", VotePrice{PricePerVote: 10, Bundles: "5,20"}, 20, 200, false},
+		{"not a bundle", VotePrice{PricePerVote: 10, Bundles: "5,20"}, 10, 0, true},
+		{
+			"discounted bundle",
+			VotePrice{PricePerVote: 10, Bundles: "5,20", Tiers: []PriceTier{{MinVotes: 20, DiscountPercent: 25}}},
+			20, 150, false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote, err := tt.price.QuoteVotes(tt.votes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("QuoteVotes(%d) error = %v, want error %v", tt.votes, err, tt.wantErr)
+			}
+			if quote.Amount != tt.want {
+				t.Errorf("QuoteVotes(%d) amount = %d, want %d", tt.votes, quote.Amount, tt.want)
+			}
+		})
+	}
+}
+
+func TestVotePriceQuoteAmount(t *testing.T) {
+	tiered := []PriceTier{{MinVotes: 10, DiscountPercent: 10}, {MinVotes: 100, DiscountPercent: 20}}
+
+	tests := []struct {
+		name    string
+		price   VotePrice
+		amount  int
+		want    int
+		wantErr bool
+	}{
+		{"flat", VotePrice{PricePerVote: 10}, 70, 7, false},
+		{"flat, not a multiple", VotePrice{PricePerVote: 10}, 75, 0, true},
+		{"zero", VotePrice{PricePerVote: 10}, 0, 0, true},
+		{"tier", VotePrice{PricePerVote: 10, Tiers: tiered}, 450, 50, false},
+		{"top tier", VotePrice{PricePerVote: 10, Tiers: tiered}, 800, 100, false},
+		{"tier boundary buys the most votes", VotePrice{PricePerVote: 10, Tiers: tiered}, 90, 10, false},
+		{"below the tier", VotePrice{PricePerVote: 10, Tiers: tiered}, 80, 8, false},
+		{"bundle", VotePrice{PricePerVote: 10, Bundles: "5,20"}, 200, 20, false},
+		{"not a bundle", VotePrice{PricePerVote: 10, Bundles: "5,20"}, 100, 0, true},
+		{
+			"discounted bundle",
+			VotePrice{PricePerVote: 10, Bundles: "5,20", Tiers: []PriceTier{{MinVotes: 20, DiscountPercent: 25}}},
+			150, 20, false,
+		},
+		{"full discount tier ignored for the bound", VotePrice{PricePerVote: 10, Tiers: []PriceTier{{MinVotes: 1000, DiscountPercent: 100}}}, 30, 3, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote, err := tt.price.QuoteAmount(tt.amount)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("QuoteAmount(%d) error = %v, want error %v", tt.amount, err, tt.wantErr)
+			}
+			if quote.Votes != tt.want {
+				t.Errorf("QuoteAmount(%d) votes = %d, want %d", tt.amount, quote.Votes, tt.want)
+			}
+			if !tt.wantErr && quote.Amount != tt.amount {
+				t.Errorf("QuoteAmount(%d) amount = %d", tt.amount, quote.Amount)
+			}
+		})
+	}
+}
+
+func TestVotePriceQuoteRoundTrip(t *testing.T) {
+	price := VotePrice{PricePerVote: 15, Tiers: []PriceTier{{MinVotes: 20, DiscountPercent: 12}, {MinVotes: 200, DiscountPercent: 30}}}
+	for votes := 1; votes <= 300; votes++ {
+		quote := price.Quote(votes)
+		back, err := price.QuoteAmount(quote.Amount)
+		if err != nil {
+			t.Fatalf("QuoteAmount(%d) for %d votes: %s", quote.Amount, votes, err)
+		}
+		if back.Votes < votes {
+			t.Errorf("%d votes cost %d, which QuoteAmount reads as only %d votes", votes, quote.Amount, back.Votes)
+		}
+	}
+}
+
+func TestVotePriceQuoteAmountMatchesSearch(t *testing.T) {
+	prices := []VotePrice{
+		{PricePerVote: 10},
+		{PricePerVote: 1, Tiers: []PriceTier{{MinVotes: 5, DiscountPercent: 50}, {MinVotes: 40, DiscountPercent: 75}}},
+		{PricePerVote: 7, Tiers: []PriceTier{{MinVotes: 3, DiscountPercent: 15}, {MinVotes: 3, DiscountPercent: 10}, {MinVotes: 60, DiscountPercent: 33}}},
+		{PricePerVote: 15, Tiers: []PriceTier{{MinVotes: 20, DiscountPercent: 12}, {MinVotes: 200, DiscountPercent: 30}}},
+	}
+
+	// Amounts up to 600 buy at most 2400 votes at the cheapest of these prices.
+	for i, price := range prices {
+		for amount := 1; amount <= 600; amount++ {
+			want := 0
+			for votes := 1; votes <= 2500; votes++ {
+				if price.Quote(votes).Amount == amount {
+					want = votes
+				}
+			}
+
+			quote, err := price.QuoteAmount(amount)
+			if want == 0 {
+				if err == nil {
+					t.Errorf("price %d: QuoteAmount(%d) = %d votes, want no match", i, amount, quote.Votes)
+				}
+				continue
+			}
+			if err != nil || quote.Votes != want {
+				t.Errorf("price %d: QuoteAmount(%d) = %d, %v; want %d votes", i, amount, quote.Votes, err, want)
+			}
+		}
+	}
+}
+
+func TestVotePriceQuoteAmountTooLarge(t *testing.T) {
+	price := VotePrice{PricePerVote: 10}
+	if _, err := price.QuoteAmount(10*maxVotesPerPurchase + 10); err == nil {
+		t.Error("quoted an amount above the most votes one payment may buy")
+	}
+	if quote, err := price.QuoteAmount(10 * maxVotesPerPurchase); err != nil || quote.Votes != maxVotesPerPurchase {
+		t.Errorf("QuoteAmount(max) = %+v, %v", quote, err)
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scopes a vote request is rate limited by.
+const (
+	LimitPhone     = "phone"
+	LimitIP        = "ip"
+	LimitCandidate = "candidate"
+)
+
+// rateLimitWindow is the period every vote limit is counted over.
+const rateLimitWindow = time.Hour
+
+// errRateLimited is returned by StartVote when a phone number, client IP or candidate has
+// used up its vote requests for now.
+var errRateLimited = errors.New("too many vote requests, please try again later")
+
+// VoteLimits caps the vote requests, and so the STK pushes, allowed per hour for each phone
+// number, client IP and candidate. Each is a token bucket that holds that many requests and
+// refills at the same rate. In the config zero turns a limit off; on an election zero uses
+// the config value and a negative number turns the limit off.
+type VoteLimits struct {
+	PhonePerHour     int `yaml:"phone_per_hour" json:"phone_per_hour"`
+	IPPerHour        int `yaml:"ip_per_hour" json:"ip_per_hour"`
+	CandidatePerHour int `yaml:"candidate_per_hour" json:"candidate_per_hour"`
+}
+
+// Over returns the limits with the election's overrides applied on top.
+func (l VoteLimits) Over(defaults VoteLimits) VoteLimits {
+	pick := func(override, fallback int) int {
+		switch {
+		case override < 0:
+			return 0
+		case override == 0:
+			return fallback
+		}
+		return override
+	}
+	return VoteLimits{
+		PhonePerHour:     pick(l.PhonePerHour, defaults.PhonePerHour),
+		IPPerHour:        pick(l.IPPerHour, defaults.IPPerHour),
+		CandidatePerHour: pick(l.CandidatePerHour, defaults.CandidatePerHour),
+	}
+}
+
+// Bucket names a token bucket that holds Limit tokens and refills Limit tokens every window.
+type Bucket struct {
+	Key   string
+	Limit int
+}
+
+// RateLimiter is a store of token buckets.
+type RateLimiter interface {
+	// Take takes a token from every bucket, or from none of them when any is empty, so a
+	// request turned away by one limit does not use up the others. It returns the index of
+	// the first empty bucket, or -1 when the tokens were taken.
+	Take(ctx context.Context, buckets []Bucket, window time.Duration) (int, error)
+}
+
+// VoteLimiter applies VoteLimits to vote requests and counts what it turns away.
+type VoteLimiter struct {
+	Store    RateLimiter
+	Defaults VoteLimits
+	// LimitIPs turns on the per-IP limit, as rate_limit.limit_ips does.
+	LimitIPs bool
+
+	mu       sync.Mutex
+	rejected map[string]int64
+}
+
+func NewVoteLimiter(store RateLimiter, defaults VoteLimits) *VoteLimiter {
+	return &VoteLimiter{Store: store, Defaults: defaults, rejected: make(map[string]int64)}
+}
+
+// Check takes one request from each bucket the vote falls under, or returns errRateLimited
+// and takes nothing when any is empty. Votes in categories without an election use the
+// defaults. An empty client IP, as with USSD and SMS votes that arrive through an aggregator,
+// skips the IP limit, as does a limiter without LimitIPs. When the store fails the vote is let
+// through, so a limiter outage cannot stop voting.
+func (l *VoteLimiter) Check(ctx context.Context, election *Election, candidateID uint, phone, clientIP string) error {
+	if l == nil {
+		return nil
+	}
+
+	limits := l.Defaults
+	scope := "global"
+	if election != nil {
+		limits = election.RateLimits.Over(l.Defaults)
+		scope = fmt.Sprintf("election:%d", election.ID)
+	}
+
+	if !l.LimitIPs {
+		clientIP = ""
+	}
+
+	checks := []struct {
+		kind  string
+		value string
+		limit int
+	}{
+		{LimitPhone, phone, limits.PhonePerHour},
+		{LimitIP, clientIP, limits.IPPerHour},
+		{LimitCandidate, strconv.FormatUint(uint64(candidateID), 10), limits.CandidatePerHour},
+	}
+	var kinds []string
+	var buckets []Bucket
+	for _, check := range checks {
+		if check.limit <= 0 || check.value == "" {
+			continue
+		}
+		kinds = append(kinds, check.kind)
+		// The braces keep a vote's keys in one Redis Cluster slot, as one script needs.
+		buckets = append(buckets, Bucket{
+			Key:   fmt.Sprintf("fedco:ratelimit:{%s}:%s:%s", scope, check.kind, check.value),
+			Limit: check.limit,
+		})
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	empty, err := l.Store.Take(ctx, buckets, rateLimitWindow)
+	if err != nil {
+		log.Printf("Rate limiter unavailable, letting vote through: %s", err)
+		return nil
+	}
+	if empty < 0 {
+		return nil
+	}
+
+	kind := kinds[empty]
+	l.mu.Lock()
+	l.rejected[kind]++
+	l.mu.Unlock()
+	log.Printf("Rate limited vote for candidate %d from %s (ip %q): %s limit of %d per hour reached",
+		candidateID, phone, clientIP, kind, buckets[empty].Limit)
+	return fmt.Errorf("%w (%s)", errRateLimited, kind)
+}
+
+// Rejected returns how many vote requests each limit has turned away since startup.
+func (l *VoteLimiter) Rejected() map[string]int64 {
+	out := map[string]int64{LimitPhone: 0, LimitIP: 0, LimitCandidate: 0}
+	if l == nil {
+		return out
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for kind, n := range l.rejected {
+		out[kind] = n
+	}
+	return out
+}
+
+// GetRateLimits reports the default limits and the requests turned away since startup.
+func (vs *VotingSystem) GetRateLimits(c *gin.Context) {
+	if vs.Limiter == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":     true,
+		"defaults":    vs.Limiter.Defaults,
+		"ip_limit_on": vs.Limiter.LimitIPs,
+		"rejected":    vs.Limiter.Rejected(),
+	})
+}
+
+// MemoryRateLimiter keeps token buckets in process. Each replica counts on its own, so use
+// RedisRateLimiter when running more than one.
+type MemoryRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	window  time.Duration
+}
+
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+func (m *MemoryRateLimiter) Take(ctx context.Context, buckets []Bucket, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.sweep(now)
+
+	refilled := make([]*tokenBucket, len(buckets))
+	empty := -1
+	for i, bucket := range buckets {
+		b, ok := m.buckets[bucket.Key]
+		if !ok {
+			b = &tokenBucket{tokens: float64(bucket.Limit), updated: now}
+			m.buckets[bucket.Key] = b
+		}
+		b.window = window
+		b.tokens += now.Sub(b.updated).Seconds() * float64(bucket.Limit) / window.Seconds()
+		if b.tokens > float64(bucket.Limit) {
+			b.tokens = float64(bucket.Limit)
+		}
+		b.updated = now
+
+		if b.tokens < 1 && empty < 0 {
+			empty = i
+		}
+		refilled[i] = b
+	}
+
+	if empty >= 0 {
+		return empty, nil
+	}
+	for _, b := range refilled {
+		b.tokens--
+	}
+	return -1, nil
+}
+
+// sweep drops buckets that have been idle long enough to be full again, at most once a minute.
+func (m *MemoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		if now.Sub(b.updated) >= b.window {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// redisTakeTokens refills the buckets in KEYS, each stored as a hash of tokens and the time it
+// was last touched in milliseconds, and takes a token from all of them only if none is empty.
+// ARGV holds the window, the time now and then each bucket's limit. It returns the 0-based index
+// of the first empty bucket, or -1. Keys expire once their bucket would be full again.
+const redisTakeTokens = `
+local window = tonumber(ARGV[1])
+local now = tonumber(ARGV[2])
+local tokens, updated = {}, {}
+local empty = -1
+for i, key in ipairs(KEYS) do
+	local limit = tonumber(ARGV[i + 2])
+	local state = redis.call('HMGET', key, 'tokens', 'updated')
+	tokens[i] = tonumber(state[1]) or limit
+	updated[i] = tonumber(state[2]) or now
+	if now > updated[i] then
+		tokens[i] = math.min(limit, tokens[i] + (now - updated[i]) * limit / window)
+		updated[i] = now
+	end
+	if tokens[i] < 1 and empty < 0 then
+		empty = i - 1
+	end
+end
+for i, key in ipairs(KEYS) do
+	if empty < 0 then
+		tokens[i] = tokens[i] - 1
+	end
+	redis.call('HSET', key, 'tokens', tostring(tokens[i]), 'updated', tostring(updated[i]))
+	redis.call('PEXPIRE', key, window)
+end
+return empty
+`
+
+// RedisRateLimiter keeps token buckets in Redis, or anything that speaks its protocol and
+// runs Lua scripts, so every replica shares them. It holds one connection and redials after
+// an error.
+type RedisRateLimiter struct {
+	Addr     string
+	Password string
+	DB       int
+	Timeout  time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func (r *RedisRateLimiter) Take(ctx context.Context, buckets []Bucket, window time.Duration) (int, error) {
+	args := []string{"EVAL", redisTakeTokens, strconv.Itoa(len(buckets))}
+	for _, bucket := range buckets {
+		args = append(args, bucket.Key)
+	}
+	args = append(args, strconv.FormatInt(window.Milliseconds(), 10), strconv.FormatInt(time.Now().UnixMilli(), 10))
+	for _, bucket := range buckets {
+		args = append(args, strconv.Itoa(bucket.Limit))
+	}
+
+	reply, err := r.do(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok || n < -1 || n >= int64(len(buckets)) {
+		return 0, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	return int(n), nil
+}
+
+// do sends one command and reads its reply, dialling first if needed.
+func (r *RedisRateLimiter) do(ctx context.Context, args ...string) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		if err := r.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := r.roundTrip(ctx, args)
+	if err != nil {
+		var redisErr redisError
+		if !errors.As(err, &redisErr) {
+			// The connection is in an unknown state; start again next time.
+			r.conn.Close()
+			r.conn = nil
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (r *RedisRateLimiter) dial(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: r.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", r.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	r.conn = conn
+	r.rd = bufio.NewReader(conn)
+
+	var setup [][]string
+	if r.Password != "" {
+		setup = append(setup, []string{"AUTH", r.Password})
+	}
+	if r.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(r.DB)})
+	}
+	for _, args := range setup {
+		if _, err := r.roundTrip(ctx, args); err != nil {
+			conn.Close()
+			r.conn = nil
+			return fmt.Errorf("failed to set up redis connection: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *RedisRateLimiter) roundTrip(ctx context.Context, args []string) (interface{}, error) {
+	deadline := time.Now().Add(r.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := r.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	// Commands go out as RESP arrays of bulk strings.
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := r.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readRESP(r.rd)
+}
+
+// redisError is an error reply from the server, after which the connection is still usable.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// readRESP reads one reply: a simple string, error, integer, bulk string or array.
+func readRESP(rd *bufio.Reader) (interface{}, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed redis reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := readRESP(rd)
+			var redisErr redisError
+			if errors.As(err, &redisErr) {
+				// Keep reading so the rest of the array is not left on the connection.
+				item = redisErr
+			} else if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown redis reply type %q", kind)
+}
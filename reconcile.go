@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fedco/audit"
+	"fedco/models"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Outcomes of the reconciliation worker looking at a vote. Each is recorded, except that a vote
+// still pending at the gateway keeps a single still_pending row updated with its latest check.
+const (
+	ReconcileCompleted    = "completed"
+	ReconcileExpired      = "expired"
+	ReconcileFailed       = "failed"
+	ReconcileStillPending = "still_pending"
+	ReconcileSkipped      = "skipped"
+	ReconcileError        = "error"
+)
+
+// ReconciliationLog records why the reconciliation worker did (or did not) change a vote.
+type ReconciliationLog struct {
+	gorm.Model
+	VoteID        uint   `gorm:"index" json:"vote_id"`
+	ExternalID    string `gorm:"index;type:varchar(100)" json:"external_id"`
+	OldStatus     string `json:"old_status"`
+	NewStatus     string `json:"new_status"`
+	GatewayStatus string `json:"gateway_status"`
+	Outcome       string `gorm:"index;type:varchar(32)" json:"outcome"`
+	Detail        string `gorm:"type:text" json:"detail"`
+}
+
+// ReconcileWorker periodically asks the payment gateway about votes that are still pending
+// because their /mpesa-callback never arrived, and completes, fails or expires them.
+type ReconcileWorker struct {
+	vs *VotingSystem
+
+	// Interval is how often the worker scans for pending votes.
+	Interval time.Duration
+	// MinAge is how long a vote must have been pending before the gateway is asked about it.
+	MinAge time.Duration
+	// ExpireAfter is how long a vote may stay pending before it is expired regardless of the gateway.
+	ExpireAfter time.Duration
+	// BatchSize caps how many votes are checked per scan.
+	BatchSize int
+}
+
+func NewReconcileWorker(vs *VotingSystem) *ReconcileWorker {
+	return &ReconcileWorker{
+		vs:          vs,
+		Interval:    time.Minute,
+		MinAge:      2 * time.Minute,
+		ExpireAfter: 24 * time.Hour,
+		BatchSize:   100,
+	}
+}
+
+// Run scans for stale pending votes every Interval until ctx is cancelled.
+func (w *ReconcileWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.RunOnce(ctx); err != nil {
+			log.Printf("Reconciliation run failed: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce checks one batch of stale pending votes that are due a check and returns how many
+// were looked at. Votes never checked come first (NULLs sort first), then those whose next
+// check is most overdue.
+func (w *ReconcileWorker) RunOnce(ctx context.Context) (int, error) {
+	var votes []Vote
+	now := time.Now()
+	if err := w.vs.DB.Where("status = ? AND created_at < ? AND (next_check_at IS NULL OR next_check_at <= ?)",
+		models.VotePending, now.Add(-w.MinAge), now).
+		Order("next_check_at ASC").
+		Order("created_at ASC").
+		Limit(w.BatchSize).
+		Find(&votes).Error; err != nil {
+		return 0, fmt.Errorf("failed to load pending votes: %w", err)
+	}
+
+	for _, vote := range votes {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		w.reconcileVote(ctx, vote)
+	}
+
+	return len(votes), nil
+}
+
+func (w *ReconcileWorker) reconcileVote(ctx context.Context, vote Vote) {
+	entry := w.checkGateway(ctx, vote)
+
+	if entry.NewStatus != entry.OldStatus {
+		updates := map[string]interface{}{"status": entry.NewStatus}
+		if entry.NewStatus != models.VoteCompleted {
+			updates["failure_reason"] = entry.Detail
+		}
+
+		// Only move votes that are still pending, so a callback that arrived meanwhile wins.
+		tx := w.vs.DB.Begin()
+		result := tx.Model(&Vote{}).
+			Where("id = ? AND status = ?", vote.ID, models.VotePending).
+			Updates(updates)
+		if result.Error != nil {
+			tx.Rollback()
+			entry.Outcome = ReconcileError
+			entry.Detail = fmt.Sprintf("failed to update vote to %s: %s", entry.NewStatus, result.Error)
+			entry.NewStatus = entry.OldStatus
+		} else if result.RowsAffected == 0 {
+			tx.Rollback()
+			entry.Outcome = ReconcileSkipped
+			entry.Detail = "vote was no longer pending"
+			entry.NewStatus = entry.OldStatus
+		} else if err := audit.Record(tx, audit.Vote(&vote, audit.ActionStatusChanged, audit.SourceReconciliation, "reconciler",
+			gin.H{"status": entry.OldStatus},
+			gin.H{"status": entry.NewStatus, "gateway_status": entry.GatewayStatus, "detail": entry.Detail})); err != nil {
+			tx.Rollback()
+			entry.Outcome = ReconcileError
+			entry.Detail = fmt.Sprintf("failed to audit update to %s: %s", entry.NewStatus, err)
+			entry.NewStatus = entry.OldStatus
+		} else if err := w.vs.voteStatusChanged(tx, withStatus(vote, entry.NewStatus), entry.OldStatus, ""); err != nil {
+			tx.Rollback()
+			entry.Outcome = ReconcileError
+			entry.Detail = err.Error()
+			entry.NewStatus = entry.OldStatus
+		} else if err := tx.Commit().Error; err != nil {
+			entry.Outcome = ReconcileError
+			entry.Detail = fmt.Sprintf("failed to commit update to %s: %s", entry.NewStatus, err)
+			entry.NewStatus = entry.OldStatus
+		} else if err := w.vs.DB.Model(&PaymentTransaction{}).
+			Where("external_id = ?", vote.ExternalID).
+			Updates(map[string]interface{}{
+				"status":             entry.NewStatus,
+				"transaction_status": entry.GatewayStatus,
+			}).Error; err != nil {
+			log.Printf("Failed to update payment transaction %s: %s", vote.ExternalID, err)
+		}
+
+		if entry.Outcome == ReconcileCompleted {
+			vote.Status = entry.NewStatus
+			w.vs.afterTallyChanged(vote)
+		}
+	}
+
+	if entry.NewStatus == models.VotePending {
+		w.scheduleNextCheck(vote)
+	}
+	if entry.Outcome == ReconcileStillPending {
+		entry.Detail = fmt.Sprintf("still pending after %d checks", vote.Checks+1)
+		w.recordStillPending(entry)
+		return
+	}
+
+	if err := w.vs.DB.Create(&entry).Error; err != nil {
+		log.Printf("Failed to record reconciliation of %s: %s", vote.ExternalID, err)
+	}
+
+	log.Printf("Reconciled vote %s: %s (%s -> %s)", vote.ExternalID, entry.Outcome, entry.OldStatus, entry.NewStatus)
+}
+
+// recordStillPending updates the vote's still_pending row, or adds one on its first check, so a
+// vote stuck at the gateway shows its latest check without adding a row every time.
+func (w *ReconcileWorker) recordStillPending(entry ReconciliationLog) {
+	var existing ReconciliationLog
+	err := w.vs.DB.Where("vote_id = ? AND outcome = ?", entry.VoteID, ReconcileStillPending).First(&existing).Error
+	switch {
+	case err == nil:
+		err = w.vs.DB.Model(&existing).Updates(map[string]interface{}{
+			"gateway_status": entry.GatewayStatus,
+			"detail":         entry.Detail,
+		}).Error
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		err = w.vs.DB.Create(&entry).Error
+	}
+	if err != nil {
+		log.Printf("Failed to record reconciliation of %s: %s", entry.ExternalID, err)
+	}
+}
+
+// checkGateway asks the gateway about vote and decides what should happen to it, without
+// changing anything.
+func (w *ReconcileWorker) checkGateway(ctx context.Context, vote Vote) ReconciliationLog {
+	entry := ReconciliationLog{
+		VoteID:     vote.ID,
+		ExternalID: vote.ExternalID,
+		OldStatus:  vote.Status,
+		NewStatus:  vote.Status,
+	}
+	expired := time.Since(vote.CreatedAt) > w.ExpireAfter
+
+	status, err := w.vs.Payments.QueryStatus(ctx, vote.ExternalID)
+	switch {
+	case err != nil && expired:
+		entry.Outcome = ReconcileExpired
+		entry.NewStatus = models.VoteExpired
+		entry.Detail = fmt.Sprintf("pending for over %s and gateway lookup failed: %s", w.ExpireAfter, err)
+	case err != nil:
+		entry.Outcome = ReconcileError
+		entry.Detail = err.Error()
+	case status.TransactionStatus == "COMPLETED":
+		entry.GatewayStatus = status.TransactionStatus
+		entry.Outcome = ReconcileCompleted
+		entry.NewStatus = models.VoteCompleted
+		entry.Detail = status.TransactionReport
+	case isFailedGatewayStatus(status.TransactionStatus):
+		entry.GatewayStatus = status.TransactionStatus
+		entry.Outcome = ReconcileFailed
+		entry.NewStatus = models.FailureStatus(status.TransactionStatus)
+		entry.Detail = gatewayFailureReason(status.TransactionStatus, status.TransactionReport)
+	case expired:
+		entry.GatewayStatus = status.TransactionStatus
+		entry.Outcome = ReconcileExpired
+		entry.NewStatus = models.VoteExpired
+		entry.Detail = fmt.Sprintf("still %s at the gateway after %s", status.TransactionStatus, w.ExpireAfter)
+	default:
+		entry.GatewayStatus = status.TransactionStatus
+		entry.Outcome = ReconcileStillPending
+	}
+	return entry
+}
+
+// scheduleNextCheck backs off a vote that is still pending, so votes stuck at the gateway are
+// asked about less and less often and cannot crowd newer ones out of a batch. The next check is
+// never later than when the vote is due to expire.
+func (w *ReconcileWorker) scheduleNextCheck(vote Vote) {
+	now := time.Now()
+	next := now.Add(retryBackoff(vote.Checks + 1))
+	if expires := vote.CreatedAt.Add(w.ExpireAfter); next.After(expires) && expires.After(now) {
+		next = expires
+	}
+
+	if err := w.vs.DB.Model(&Vote{}).
+		Where("id = ? AND status = ?", vote.ID, models.VotePending).
+		Updates(map[string]interface{}{
+			"last_checked_at": now,
+			"next_check_at":   next,
+			"checks":          vote.Checks + 1,
+		}).Error; err != nil {
+		log.Printf("Failed to schedule the next check of %s: %s", vote.ExternalID, err)
+	}
+}
+
+// withStatus returns a copy of vote with its status changed.
+func withStatus(vote Vote, status string) Vote {
+	vote.Status = status
+	return vote
+}
+
+// isFailedGatewayStatus reports whether the gateway considers a payment finished without success.
+func isFailedGatewayStatus(status string) bool {
+	switch status {
+	case "FAILED", "CANCELLED", "CANCELED", "REVERSED", "EXPIRED", "DECLINED":
+		return true
+	}
+	return false
+}
+
+// TriggerReconciliation runs one reconciliation pass on demand.
+func (w *ReconcileWorker) TriggerReconciliation(c *gin.Context) {
+	checked, err := w.RunOnce(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile pending votes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Reconciliation completed", "checked": checked})
+}
+
+// GetReconciliationLogs lists reconciliation outcomes, newest first.
+func (vs *VotingSystem) GetReconciliationLogs(c *gin.Context) {
+	query := vs.DB.Model(&ReconciliationLog{}).Order("id DESC")
+
+	if externalID := c.Query("external_id"); externalID != "" {
+		query = query.Where("external_id = ?", externalID)
+	}
+	if outcome := c.Query("outcome"); outcome != "" {
+		query = query.Where("outcome = ?", outcome)
+	}
+
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	var logs []ReconciliationLog
+	if err := query.Limit(limit).Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reconciliation logs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, logs)
+}
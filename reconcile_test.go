@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fedco/models"
+	"testing"
+	"time"
+)
+
+func TestReconcileCheckGateway(t *testing.T) {
+	tests := []struct {
+		name        string
+		initiate    bool
+		settle      string
+		age         time.Duration
+		wantOutcome string
+		wantStatus  string
+	}{
+		{"pending", true, "", 5 * time.Minute, ReconcileStillPending, models.VotePending},
+		{"completed", true, "COMPLETED", 5 * time.Minute, ReconcileCompleted, models.VoteCompleted},
+		{"failed", true, "FAILED", 5 * time.Minute, ReconcileFailed, models.VoteFailed},
+		{"cancelled", true, "CANCELLED", 5 * time.Minute, ReconcileFailed, models.VoteCancelled},
+		{"expired while pending", true, "", 25 * time.Hour, ReconcileExpired, models.VoteExpired},
+		{"completed after expiry", true, "COMPLETED", 25 * time.Hour, ReconcileCompleted, models.VoteCompleted},
+		{"lookup failed", false, "", 5 * time.Minute, ReconcileError, models.VotePending},
+		{"lookup failed after expiry", false, "", 25 * time.Hour, ReconcileExpired, models.VoteExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payments := NewFakePaymentProvider()
+			w := NewReconcileWorker(&VotingSystem{Payments: payments})
+
+			vote := Vote{ExternalID: "FEDCO-TEST-1", Status: models.VotePending, Amount: 50}
+			vote.ID = 1
+			vote.CreatedAt = time.Now().Add(-tt.age)
+
+			if tt.initiate {
+				if _, err := payments.InitiatePayment(context.Background(), PaymentRequest{ExternalID: vote.ExternalID, Amount: vote.Amount}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.settle != "" {
+				if err := payments.Settle(vote.ExternalID, tt.settle); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			entry := w.checkGateway(context.Background(), vote)
+			if entry.Outcome != tt.wantOutcome {
+				t.Errorf("Outcome = %q, want %q (detail: %s)", entry.Outcome, tt.wantOutcome, entry.Detail)
+			}
+			if entry.NewStatus != tt.wantStatus {
+				t.Errorf("NewStatus = %q, want %q", entry.NewStatus, tt.wantStatus)
+			}
+			if entry.OldStatus != models.VotePending {
+				t.Errorf("OldStatus = %q, want %q", entry.OldStatus, models.VotePending)
+			}
+		})
+	}
+}
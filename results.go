@@ -0,0 +1,216 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// resultsSnapshotTTL bounds how stale a cached results tree can get if an invalidation is missed.
+const resultsSnapshotTTL = time.Minute
+
+type resultsSnapshot struct {
+	categories []CategoryResult
+	builtAt    time.Time
+}
+
+// ResultsCache keeps the last built results tree per election so /checkcandidatesposition does
+// not rebuild it on every poll. It is invalidated whenever a vote completes or the tree changes.
+type ResultsCache struct {
+	mu         sync.Mutex
+	build      sync.Mutex
+	generation uint64
+	snapshots  map[uint]resultsSnapshot
+}
+
+func NewResultsCache() *ResultsCache {
+	return &ResultsCache{snapshots: make(map[uint]resultsSnapshot)}
+}
+
+// Invalidate drops every cached snapshot.
+func (rc *ResultsCache) Invalidate() {
+	rc.mu.Lock()
+	rc.generation++
+	rc.snapshots = make(map[uint]resultsSnapshot)
+	rc.mu.Unlock()
+}
+
+func (rc *ResultsCache) get(electionID uint) (resultsSnapshot, uint64, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	snapshot, ok := rc.snapshots[electionID]
+	if ok && time.Since(snapshot.builtAt) > resultsSnapshotTTL {
+		ok = false
+	}
+	return snapshot, rc.generation, ok
+}
+
+// put stores a snapshot unless the cache was invalidated while it was being built.
+func (rc *ResultsCache) put(electionID uint, generation uint64, snapshot resultsSnapshot) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.generation == generation {
+		rc.snapshots[electionID] = snapshot
+	}
+}
+
+// CachedResults returns the results tree for an election (0 for all categories), from cache when fresh.
+func (vs *VotingSystem) CachedResults(electionID uint) ([]CategoryResult, time.Time, error) {
+	if snapshot, _, ok := vs.ResultsCache.get(electionID); ok {
+		return snapshot.categories, snapshot.builtAt, nil
+	}
+
+	// Build one tree at a time so a burst of polls after an invalidation costs a single rebuild.
+	vs.ResultsCache.build.Lock()
+	defer vs.ResultsCache.build.Unlock()
+
+	snapshot, generation, ok := vs.ResultsCache.get(electionID)
+	if ok {
+		return snapshot.categories, snapshot.builtAt, nil
+	}
+
+	categories, err := vs.BuildResults(electionID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	snapshot = resultsSnapshot{categories: categories, builtAt: time.Now()}
+	vs.ResultsCache.put(electionID, generation, snapshot)
+	return snapshot.categories, snapshot.builtAt, nil
+}
+
+// BuildResults assembles the category → position → candidate → voter tree with four queries,
+// however many candidates there are.
+func (vs *VotingSystem) BuildResults(electionID uint) ([]CategoryResult, error) {
+	return buildResults(vs.DB, electionID)
+}
+
+// buildResults is BuildResults on db, which may be a transaction.
+func buildResults(db *gorm.DB, electionID uint) ([]CategoryResult, error) {
+	scope := func(q *gorm.DB) *gorm.DB {
+		if electionID != 0 {
+			return q.Where("categories.election_id = ?", electionID)
+		}
+		return q
+	}
+
+	var categories []Category
+	if err := db.Model(&Category{}).Scopes(scope).Order("categories.id ASC").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	var positions []Position
+	if err := db.Model(&Position{}).
+		Joins("JOIN categories ON categories.id = positions.category_id AND categories.deleted_at IS NULL").
+		Scopes(scope).
+		Order("positions.id ASC").
+		Find(&positions).Error; err != nil {
+		return nil, err
+	}
+
+	var candidates []struct {
+		CandidateResult
+		PositionID uint
+	}
+	if err := db.Model(&Candidate{}).
+		Select("candidates.id, candidates.name, candidates.position_id, COUNT(DISTINCT votes.voter_id) as voters_count, COALESCE(SUM(votes.amount), 0) as amount, COALESCE(SUM("+legacyVoteCountExpr+"), 0) as vote_count").
+		Joins("JOIN positions ON positions.id = candidates.position_id AND positions.deleted_at IS NULL").
+		Joins("JOIN categories ON categories.id = positions.category_id AND categories.deleted_at IS NULL").
+		Joins("LEFT JOIN votes ON candidates.id = votes.candidate_id AND votes.status = ?", "completed").
+		Scopes(scope).
+		Group("candidates.id, candidates.name, candidates.position_id").
+		Order("voters_count DESC, candidates.id ASC").
+		Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	var voters []struct {
+		VoterResult
+		CandidateID uint
+	}
+	if err := db.Table("votes").
+		Select("votes.candidate_id, voters.name, voters.phone, COUNT(votes.id) as votes").
+		Joins("JOIN voters ON votes.voter_id = voters.id").
+		Joins("JOIN candidates ON candidates.id = votes.candidate_id AND candidates.deleted_at IS NULL").
+		Joins("JOIN positions ON positions.id = candidates.position_id AND positions.deleted_at IS NULL").
+		Joins("JOIN categories ON categories.id = positions.category_id AND categories.deleted_at IS NULL").
+		Where("votes.status = ?", "completed").
+		Scopes(scope).
+		Group("votes.candidate_id, voters.id, voters.name, voters.phone").
+		Scan(&voters).Error; err != nil {
+		return nil, err
+	}
+
+	votersByCandidate := make(map[uint][]VoterResult)
+	for _, voter := range voters {
+		votersByCandidate[voter.CandidateID] = append(votersByCandidate[voter.CandidateID], voter.VoterResult)
+	}
+
+	candidatesByPosition := make(map[uint][]CandidateResult)
+	totalByPosition := make(map[uint]int)
+	for _, candidate := range candidates {
+		result := candidate.CandidateResult
+		result.Voters = votersByCandidate[result.ID]
+		if result.Voters == nil {
+			result.Voters = []VoterResult{}
+		}
+		candidatesByPosition[candidate.PositionID] = append(candidatesByPosition[candidate.PositionID], result)
+		totalByPosition[candidate.PositionID] += result.VoteCount
+	}
+
+	positionsByCategory := make(map[uint][]PositionResult)
+	for _, position := range positions {
+		total := totalByPosition[position.ID]
+		results := candidatesByPosition[position.ID]
+		if results == nil {
+			results = []CandidateResult{}
+		}
+		for i := range results {
+			results[i].AllVoteCount = total
+			if total > 0 {
+				results[i].VotePercentage = float64(int(float64(results[i].VoteCount) / float64(total) * 100))
+			}
+		}
+		positionsByCategory[position.CategoryID] = append(positionsByCategory[position.CategoryID], PositionResult{
+			ID:         position.ID,
+			Name:       position.Name,
+			Candidates: results,
+		})
+	}
+
+	var categoryResults []CategoryResult
+	for _, category := range categories {
+		categoryResults = append(categoryResults, CategoryResult{
+			ID:        category.ID,
+			Name:      category.Name,
+			Positions: positionsByCategory[category.ID],
+		})
+	}
+
+	return categoryResults, nil
+}
+
+func (vs *VotingSystem) CheckCandidatesPosition(c *gin.Context) {
+	var electionID uint
+	if v := c.Query("election_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid election ID"})
+			return
+		}
+		electionID = uint(id)
+	}
+
+	categoryResults, builtAt, err := vs.CachedResults(electionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve results"})
+		return
+	}
+
+	c.Header("X-Results-Generated-At", builtAt.UTC().Format(time.RFC3339))
+	c.JSON(http.StatusOK, categoryResults)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sseHeartbeatInterval keeps idle result streams alive through proxies that drop silent connections.
+const sseHeartbeatInterval = 25 * time.Second
+
+// CandidateTally is one candidate's standing in a TallyUpdate.
+type CandidateTally struct {
+	ID             uint    `json:"id"`
+	Name           string  `json:"name"`
+	VoteCount      int     `json:"vote_count"`
+	VotePercentage float64 `json:"vote_percentage"`
+	Amount         int     `json:"amount"`
+}
+
+// TallyUpdate is pushed to result stream subscribers whenever a vote in a position completes.
+type TallyUpdate struct {
+	ElectionID *uint            `json:"election_id"`
+	CategoryID uint             `json:"category_id"`
+	PositionID uint             `json:"position_id"`
+	Position   string           `json:"position"`
+	TotalVotes int              `json:"total_votes"`
+	Candidates []CandidateTally `json:"candidates"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+}
+
+// ResultsFilter narrows a subscription to one election, category or position. Zero fields match anything.
+type ResultsFilter struct {
+	ElectionID uint
+	CategoryID uint
+	PositionID uint
+}
+
+func (f ResultsFilter) matches(u *TallyUpdate) bool {
+	if f.ElectionID != 0 && (u.ElectionID == nil || *u.ElectionID != f.ElectionID) {
+		return false
+	}
+	if f.CategoryID != 0 && u.CategoryID != f.CategoryID {
+		return false
+	}
+	if f.PositionID != 0 && u.PositionID != f.PositionID {
+		return false
+	}
+	return true
+}
+
+type resultsSubscriber struct {
+	filter  ResultsFilter
+	updates chan *TallyUpdate
+}
+
+// ResultsBroker fans tally updates out to every connected result stream.
+type ResultsBroker struct {
+	mu          sync.Mutex
+	subscribers map[*resultsSubscriber]struct{}
+}
+
+func NewResultsBroker() *ResultsBroker {
+	return &ResultsBroker{subscribers: make(map[*resultsSubscriber]struct{})}
+}
+
+func (b *ResultsBroker) Subscribe(filter ResultsFilter) *resultsSubscriber {
+	sub := &resultsSubscriber{filter: filter, updates: make(chan *TallyUpdate, 16)}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *ResultsBroker) Unsubscribe(sub *resultsSubscriber) {
+	b.mu.Lock()
+	delete(b.subscribers, sub)
+	b.mu.Unlock()
+}
+
+// Publish delivers an update to matching subscribers. Slow subscribers miss updates rather than
+// holding up the others; the next update for the position carries the full tally again.
+func (b *ResultsBroker) Publish(update *TallyUpdate) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		if !sub.filter.matches(update) {
+			continue
+		}
+		select {
+		case sub.updates <- update:
+		default:
+		}
+	}
+}
+
+// PositionTally computes the current completed-vote tally of one position.
+func (vs *VotingSystem) PositionTally(positionID uint) (*TallyUpdate, error) {
+	var position Position
+	if err := vs.DB.Preload("Category").First(&position, positionID).Error; err != nil {
+		return nil, err
+	}
+
+	var candidates []CandidateTally
+	if err := vs.DB.Model(&Candidate{}).
+		Select("candidates.id, candidates.name, COALESCE(SUM(votes.amount), 0) as amount, COALESCE(SUM("+legacyVoteCountExpr+"), 0) as vote_count").
+		Joins("LEFT JOIN votes ON candidates.id = votes.candidate_id AND votes.status = ?", "completed").
+		Where("candidates.position_id = ?", positionID).
+		Group("candidates.id, candidates.name").
+		Order("vote_count DESC").
+		Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for _, candidate := range candidates {
+		total += candidate.VoteCount
+	}
+	for i := range candidates {
+		if total > 0 {
+			candidates[i].VotePercentage = float64(int(float64(candidates[i].VoteCount) / float64(total) * 100))
+		}
+	}
+
+	return &TallyUpdate{
+		ElectionID: position.Category.ElectionID,
+		CategoryID: position.CategoryID,
+		PositionID: position.ID,
+		Position:   position.Name,
+		TotalVotes: total,
+		Candidates: candidates,
+		UpdatedAt:  time.Now(),
+	}, nil
+}
+
+// afterTallyChanged runs once a vote has been committed as completed, or a completed vote as
+// reversed, whichever path changed it.
+func (vs *VotingSystem) afterTallyChanged(vote Vote) {
+	vs.ResultsCache.Invalidate()
+
+	go func() {
+		var candidate Candidate
+		if err := vs.DB.First(&candidate, vote.CandidateID).Error; err != nil {
+			log.Printf("Failed to load candidate %d for tally update: %s", vote.CandidateID, err)
+			return
+		}
+
+		update, err := vs.PositionTally(candidate.PositionID)
+		if err != nil {
+			log.Printf("Failed to compute tally for position %d: %s", candidate.PositionID, err)
+			return
+		}
+		vs.Results.Publish(update)
+	}()
+}
+
+// afterTalliesChanged runs afterTallyChanged for a batch of changed votes, once per candidate.
+func (vs *VotingSystem) afterTalliesChanged(votes []Vote) {
+	refreshed := make(map[uint]bool)
+	for _, vote := range votes {
+		if !refreshed[vote.CandidateID] {
+			refreshed[vote.CandidateID] = true
+			vs.afterTallyChanged(vote)
+		}
+	}
+}
+
+// StreamResults pushes tally updates as Server-Sent Events. Optional election_id, category_id
+// and position_id query parameters narrow the stream.
+func (vs *VotingSystem) StreamResults(c *gin.Context) {
+	var filter ResultsFilter
+	for name, dst := range map[string]*uint{
+		"election_id": &filter.ElectionID,
+		"category_id": &filter.CategoryID,
+		"position_id": &filter.PositionID,
+	} {
+		if v := c.Query(name); v != "" {
+			id, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+				return
+			}
+			*dst = uint(id)
+		}
+	}
+
+	sub := vs.Results.Subscribe(filter)
+	defer vs.Results.Unsubscribe(sub)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	// Start a single-position stream with its current tally so clients need no extra request.
+	if filter.PositionID != 0 {
+		if update, err := vs.PositionTally(filter.PositionID); err == nil && filter.matches(update) {
+			c.SSEvent("tally", update)
+		}
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case update := <-sub.updates:
+			c.SSEvent("tally", update)
+		case <-heartbeat.C:
+			c.SSEvent("ping", time.Now().Unix())
+		}
+		return true
+	})
+}
@@ -0,0 +1,683 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fedco/audit"
+	"fedco/handlers"
+	"fedco/models"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Issues flagged on each settlement line.
+const (
+	SettlementOK                 = "ok"
+	SettlementPaidButPending     = "paid_but_pending"     // the statement shows a payment the vote does not count
+	SettlementCompletedButUnpaid = "completed_but_unpaid" // the vote counts but the statement has no successful payment
+	SettlementAmountMismatch     = "amount_mismatch"
+	SettlementUnmatched          = "unmatched"
+	SettlementDuplicate          = "duplicate"
+	SettlementInvalid            = "invalid"
+)
+
+// maxSettlementUpload caps the size of an uploaded statement.
+const maxSettlementUpload = 20 << 20
+
+// SettlementImport is one settlement statement checked against the votes table.
+type SettlementImport struct {
+	gorm.Model
+	Filename   string     `json:"filename"`
+	PeriodFrom *time.Time `json:"period_from"`
+	PeriodTo   *time.Time `json:"period_to"`
+	Rows       int        `json:"rows"`
+	// Applied is set when fixes were asked for; Fixed counts the lines they changed.
+	Applied            bool             `json:"applied"`
+	Fixed              int              `json:"fixed"`
+	OK                 int              `json:"ok"`
+	PaidButPending     int              `json:"paid_but_pending"`
+	CompletedButUnpaid int              `json:"completed_but_unpaid"`
+	AmountMismatch     int              `json:"amount_mismatch"`
+	Unmatched          int              `json:"unmatched"`
+	Duplicate          int              `json:"duplicate"`
+	Invalid            int              `json:"invalid"`
+	ImportedBy         string           `json:"imported_by"`
+	Lines              []SettlementLine `gorm:"foreignKey:ImportID" json:"lines,omitempty"`
+
+	// fixedVotes are the votes changed by applied fixes, for refreshing live results.
+	fixedVotes []Vote
+}
+
+// SettlementLine is one statement row, or a completed vote the statement is missing (Row 0).
+// Row is the line number in the CSV file.
+type SettlementLine struct {
+	gorm.Model
+	ImportID        uint       `gorm:"index" json:"import_id"`
+	Row             int        `json:"row"`
+	ExternalID      string     `gorm:"index;type:varchar(100)" json:"external_id"`
+	Receipt         string     `gorm:"type:varchar(32)" json:"receipt"`
+	Amount          int        `json:"amount"`
+	StatementStatus string     `json:"statement_status"`
+	PaidAt          *time.Time `json:"paid_at"`
+	VoteID          *uint      `json:"vote_id"`
+	VoteStatus      string     `json:"vote_status"`
+	VoteAmount      int        `json:"vote_amount"`
+	Issue           string     `gorm:"index;type:varchar(32)" json:"issue"`
+	Detail          string     `gorm:"type:text" json:"detail,omitempty"`
+	Fixed           bool       `json:"fixed"`
+}
+
+// SettlementOptions control ImportSettlement.
+type SettlementOptions struct {
+	Filename string
+	// Apply completes paid-but-pending votes and corrects mismatched amounts.
+	Apply bool
+	// ApplyUnpaid also fails completed votes the statement shows as unpaid. It needs Apply.
+	ApplyUnpaid bool
+	// From and To bound the votes checked for completed-but-unpaid. When nil they default to
+	// the earliest and latest payment times in the statement.
+	From, To *time.Time
+	Actor    string
+	// StatusChanged runs before commit when a fix moves a vote from oldStatus, to fill the
+	// outboxes; an error rolls the fix back.
+	StatusChanged func(tx *gorm.DB, vote Vote, oldStatus, receipt string) error
+}
+
+// settlementColumns maps the normalized header names used by aggregators and M-Pesa
+// statements to the fields we read.
+var settlementColumns = map[string][]string{
+	"external_id": {"externalid", "reference", "accountreference", "accountno", "account", "billrefnumber", "billreference", "merchantreference"},
+	"receipt":     {"receipt", "receiptno", "receiptnumber", "mpesareceipt", "mpesareceiptnumber", "transactionid", "transid", "secureid"},
+	"amount":      {"amount", "paidin", "credit", "transactionamount"},
+	"status":      {"status", "transactionstatus"},
+	"time":        {"completiontime", "transactiondate", "transtime", "completedat", "date", "time"},
+}
+
+var settlementTimeLayouts = []string{
+	time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "02/01/2006 15:04:05", "02/01/2006 15:04",
+	"02-01-2006 15:04:05", "02-01-2006 15:04", "2006-01-02", "02/01/2006",
+}
+
+var errInvalidStatement = errors.New("invalid settlement statement")
+
+// ImportSettlement reads a settlement statement CSV, matches each row to a vote by external ID
+// or receipt number, flags disagreements, optionally fixes them and stores the result.
+//
+// Completed votes paid in the statement period that no row accounts for are flagged as
+// completed-but-unpaid. Without opts.From and opts.To the period is the whole days the rows
+// fall on, so a statement should cover whole days.
+//
+// The import and its lines are saved before any fix is applied, and each fix updates its line
+// in the same transaction, so the record always shows what was changed.
+func ImportSettlement(db *gorm.DB, r io.Reader, opts SettlementOptions) (*SettlementImport, error) {
+	lines, err := readSettlementCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	imp := &SettlementImport{Filename: opts.Filename, Rows: len(lines), ImportedBy: opts.Actor}
+	seen, err := matchSettlementLines(db, lines)
+	if err != nil {
+		return nil, err
+	}
+
+	imp.PeriodFrom, imp.PeriodTo = settlementPeriod(lines, opts.From, opts.To)
+	if imp.PeriodFrom != nil && imp.PeriodTo != nil {
+		// A vote was paid when the gateway's callback arrived or, for votes completed some other
+		// way, when the vote was last changed.
+		var completed []Vote
+		if err := db.Select("votes.id, votes.external_id, votes.status, votes.amount").
+			Joins("LEFT JOIN payment_transactions ON payment_transactions.external_id = votes.external_id AND payment_transactions.deleted_at IS NULL").
+			Where("votes.status = ? AND COALESCE(payment_transactions.callback_at, votes.updated_at) BETWEEN ? AND ?",
+				models.VoteCompleted, imp.PeriodFrom, imp.PeriodTo).
+			Find(&completed).Error; err != nil {
+			return nil, fmt.Errorf("failed to load completed votes: %w", err)
+		}
+		for _, vote := range completed {
+			if seen[vote.ID] {
+				continue
+			}
+			id := vote.ID
+			lines = append(lines, SettlementLine{
+				ExternalID: vote.ExternalID,
+				VoteID:     &id,
+				VoteStatus: vote.Status,
+				VoteAmount: vote.Amount,
+				Issue:      SettlementCompletedButUnpaid,
+				Detail:     "completed vote is missing from the statement",
+			})
+		}
+	}
+
+	for _, line := range lines {
+		switch line.Issue {
+		case SettlementOK:
+			imp.OK++
+		case SettlementPaidButPending:
+			imp.PaidButPending++
+		case SettlementCompletedButUnpaid:
+			imp.CompletedButUnpaid++
+		case SettlementAmountMismatch:
+			imp.AmountMismatch++
+		case SettlementUnmatched:
+			imp.Unmatched++
+		case SettlementDuplicate:
+			imp.Duplicate++
+		case SettlementInvalid:
+			imp.Invalid++
+		}
+	}
+
+	imp.Applied = opts.Apply
+	tx := db.Begin()
+	if err := tx.Omit("Lines").Create(imp).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to record settlement import: %w", err)
+	}
+	for i := range lines {
+		lines[i].ImportID = imp.ID
+	}
+	if len(lines) > 0 {
+		if err := tx.CreateInBatches(lines, 500).Error; err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to record settlement lines: %w", err)
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to record settlement import: %w", err)
+	}
+
+	if opts.Apply {
+		for i := range lines {
+			detail := lines[i].Detail
+			vote, err := fixSettlementLine(db, &lines[i], opts)
+			if err != nil {
+				log.Printf("Failed to apply settlement fix for %s: %s", lines[i].ExternalID, err)
+				lines[i].Detail += "; fix failed"
+			}
+			if vote != nil {
+				imp.Fixed++
+				imp.fixedVotes = append(imp.fixedVotes, *vote)
+				continue
+			}
+			if lines[i].Detail != detail {
+				if err := db.Model(&lines[i]).Update("detail", lines[i].Detail).Error; err != nil {
+					log.Printf("Failed to record why settlement line %d was not fixed: %s", lines[i].ID, err)
+				}
+			}
+		}
+	}
+
+	imp.Lines = lines
+	return imp, nil
+}
+
+// settlementPeriod returns from and to, or where either is nil, the start of the first day or
+// the end of the last day on which a line was paid.
+func settlementPeriod(lines []SettlementLine, from, to *time.Time) (*time.Time, *time.Time) {
+	var first, last *time.Time
+	for _, line := range lines {
+		if line.PaidAt == nil {
+			continue
+		}
+		if first == nil || line.PaidAt.Before(*first) {
+			first = line.PaidAt
+		}
+		if last == nil || line.PaidAt.After(*last) {
+			last = line.PaidAt
+		}
+	}
+
+	if from == nil && first != nil {
+		start := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, first.Location())
+		from = &start
+	}
+	if to == nil && last != nil {
+		end := time.Date(last.Year(), last.Month(), last.Day()+1, 0, 0, 0, 0, last.Location()).Add(-time.Nanosecond)
+		to = &end
+	}
+	return from, to
+}
+
+func readSettlementCSV(r io.Reader) ([]SettlementLine, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	// Statements often start with a few lines about the account before the header row.
+	var columns map[string]int
+	var lines []SettlementLine
+	for records := 1; ; records++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidStatement, err)
+		}
+
+		if columns == nil {
+			if records > 20 {
+				break
+			}
+			columns = settlementHeader(record)
+			continue
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		row, _ := reader.FieldPos(0)
+		lines = append(lines, parseSettlementRow(row, record, columns))
+	}
+
+	if columns == nil {
+		return nil, fmt.Errorf("%w: no header with an amount and a reference or receipt column", errInvalidStatement)
+	}
+	return lines, nil
+}
+
+// settlementHeader returns the column of each field if record is a header row, or nil.
+func settlementHeader(record []string) map[string]int {
+	names := make([]string, len(record))
+	for i, cell := range record {
+		names[i] = strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, strings.ToLower(cell))
+	}
+
+	columns := make(map[string]int)
+	for field, aliases := range settlementColumns {
+	search:
+		for _, alias := range aliases {
+			for i, name := range names {
+				if name == alias {
+					columns[field] = i
+					break search
+				}
+			}
+		}
+	}
+
+	_, hasAmount := columns["amount"]
+	_, hasReference := columns["external_id"]
+	_, hasReceipt := columns["receipt"]
+	if !hasAmount || !hasReference && !hasReceipt {
+		return nil
+	}
+	return columns
+}
+
+func parseSettlementRow(row int, record []string, columns map[string]int) SettlementLine {
+	get := func(field string) string {
+		i, ok := columns[field]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	line := SettlementLine{
+		Row:             row,
+		ExternalID:      get("external_id"),
+		Receipt:         get("receipt"),
+		StatementStatus: get("status"),
+	}
+	if line.ExternalID == "" && line.Receipt == "" {
+		line.Issue = SettlementInvalid
+		line.Detail = "row has no reference or receipt number"
+		return line
+	}
+
+	amount, err := handlers.ParseShillings(strings.TrimLeft(get("amount"), "KESkesh. "))
+	if err != nil {
+		line.Issue = SettlementInvalid
+		line.Detail = err.Error()
+		return line
+	}
+	line.Amount = amount
+
+	if value := get("time"); value != "" {
+		for _, layout := range settlementTimeLayouts {
+			if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+				line.PaidAt = &t
+				break
+			}
+		}
+	}
+	return line
+}
+
+// settlementPaid reports whether a statement status means the money arrived. Statements
+// without a status column only list settled payments.
+func settlementPaid(status string) bool {
+	switch strings.ToLower(status) {
+	case "", "completed", "complete", "success", "successful", "paid", "settled":
+		return true
+	}
+	return false
+}
+
+// matchSettlementLines finds each line's vote and flags the line. It returns the IDs of the
+// votes that some line accounted for.
+func matchSettlementLines(db *gorm.DB, lines []SettlementLine) (map[uint]bool, error) {
+	var receipts []string
+	for _, line := range lines {
+		if line.Issue == "" && line.Receipt != "" {
+			receipts = append(receipts, line.Receipt)
+		}
+	}
+
+	// Receipts recorded on the ledger by earlier reconciliations, or sent as the gateway's secure ID.
+	byReceipt := make(map[string]string)
+	for start := 0; start < len(receipts); start += 500 {
+		chunk := receipts[start:min(start+500, len(receipts))]
+		var payments []PaymentTransaction
+		if err := db.Select("external_id, receipt, secure_id").
+			Where("receipt IN ? OR secure_id IN ?", chunk, chunk).
+			Find(&payments).Error; err != nil {
+			return nil, fmt.Errorf("failed to look up receipts: %w", err)
+		}
+		for _, payment := range payments {
+			if payment.Receipt != "" {
+				byReceipt[payment.Receipt] = payment.ExternalID
+			}
+			if payment.SecureId != "" {
+				byReceipt[payment.SecureId] = payment.ExternalID
+			}
+		}
+	}
+
+	var externalIDs []string
+	for _, line := range lines {
+		if line.Issue != "" {
+			continue
+		}
+		if line.ExternalID != "" {
+			externalIDs = append(externalIDs, line.ExternalID)
+		}
+		if id, ok := byReceipt[line.Receipt]; ok {
+			externalIDs = append(externalIDs, id)
+		}
+	}
+
+	votes := make(map[string]Vote)
+	for start := 0; start < len(externalIDs); start += 500 {
+		chunk := externalIDs[start:min(start+500, len(externalIDs))]
+		var found []Vote
+		if err := db.Where("external_id IN ?", chunk).Find(&found).Error; err != nil {
+			return nil, fmt.Errorf("failed to look up votes: %w", err)
+		}
+		for _, vote := range found {
+			votes[vote.ExternalID] = vote
+		}
+	}
+
+	seen := make(map[uint]int)
+	for i := range lines {
+		line := &lines[i]
+		if line.Issue != "" {
+			continue
+		}
+
+		vote, ok := votes[line.ExternalID]
+		if !ok {
+			vote, ok = votes[byReceipt[line.Receipt]]
+		}
+		if !ok {
+			line.Issue = SettlementUnmatched
+			continue
+		}
+
+		id := vote.ID
+		line.ExternalID = vote.ExternalID
+		line.VoteID = &id
+		line.VoteStatus = vote.Status
+		line.VoteAmount = vote.Amount
+		if first, ok := seen[vote.ID]; ok {
+			line.Issue = SettlementDuplicate
+			line.Detail = fmt.Sprintf("same vote as row %d", first)
+			continue
+		}
+		seen[vote.ID] = line.Row
+
+		paid := settlementPaid(line.StatementStatus)
+		switch {
+		case paid && vote.Status != models.VoteCompleted:
+			line.Issue = SettlementPaidButPending
+			line.Detail = fmt.Sprintf("statement shows a payment of %d but the vote is %s", line.Amount, vote.Status)
+		case !paid && vote.Status == models.VoteCompleted:
+			line.Issue = SettlementCompletedButUnpaid
+			line.Detail = fmt.Sprintf("statement status is %q", line.StatementStatus)
+		case paid && line.Amount != vote.Amount:
+			line.Issue = SettlementAmountMismatch
+			line.Detail = fmt.Sprintf("statement shows %d but the vote has %d", line.Amount, vote.Amount)
+		default:
+			line.Issue = SettlementOK
+		}
+	}
+
+	accounted := make(map[uint]bool, len(seen))
+	for id := range seen {
+		accounted[id] = true
+	}
+	return accounted, nil
+}
+
+// fixSettlementLine brings a flagged line's vote in line with the statement and returns the
+// changed vote, or nil when the line needs no fix or cannot be fixed safely. The saved line and
+// its import are marked fixed in the same transaction.
+func fixSettlementLine(db *gorm.DB, line *SettlementLine, opts SettlementOptions) (*Vote, error) {
+	switch line.Issue {
+	case SettlementPaidButPending, SettlementAmountMismatch:
+	case SettlementCompletedButUnpaid:
+		if !opts.ApplyUnpaid {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+	if line.VoteStatus == models.VoteReversed {
+		line.Detail += "; not fixed: the gateway reversed this payment"
+		return nil, nil
+	}
+
+	tx := db.Begin()
+	var vote Vote
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&vote, *line.VoteID).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if vote.Status != line.VoteStatus || vote.Amount != line.VoteAmount {
+		tx.Rollback()
+		line.Detail += "; not fixed: the vote changed during the import"
+		return nil, nil
+	}
+
+	oldStatus := vote.Status
+	oldValues := gin.H{"status": vote.Status, "amount": vote.Amount, "vote_count": vote.VoteCount}
+	action := audit.ActionStatusChanged
+	if line.Issue == SettlementCompletedButUnpaid {
+		vote.Status = models.VoteFailed
+		vote.FailureReason = fmt.Sprintf("settlement statement %s shows no successful payment", opts.Filename)
+	} else {
+		if line.Issue == SettlementAmountMismatch {
+			action = audit.ActionAmountChanged
+		}
+		// The votes bought only change with the amount, and then go back through the tiers.
+		if line.Amount != vote.Amount {
+			votes, err := requoteVote(tx, &vote, line.Amount)
+			if err != nil {
+				tx.Rollback()
+				line.Detail += fmt.Sprintf("; not fixed, review manually: %s", err)
+				return nil, nil
+			}
+			vote.Amount = line.Amount
+			vote.VoteCount = votes
+		}
+		vote.Status = models.VoteCompleted
+		vote.FailureReason = ""
+	}
+
+	if err := tx.Model(&vote).Updates(map[string]interface{}{
+		"status":         vote.Status,
+		"amount":         vote.Amount,
+		"vote_count":     vote.VoteCount,
+		"failure_reason": vote.FailureReason,
+	}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	newValues := gin.H{"status": vote.Status, "amount": vote.Amount, "vote_count": vote.VoteCount, "statement": opts.Filename}
+	if err := audit.Record(tx, audit.Vote(&vote, action, audit.SourceSettlement, opts.Actor, oldValues, newValues)); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Model(&PaymentTransaction{}).Where("external_id = ?", vote.ExternalID).
+		Update("status", vote.Status).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if line.Receipt != "" {
+		if err := tx.Model(&PaymentTransaction{}).
+			Where("external_id = ? AND (receipt = '' OR receipt IS NULL)", vote.ExternalID).
+			Update("receipt", line.Receipt).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if vote.Status != oldStatus && opts.StatusChanged != nil {
+		if err := opts.StatusChanged(tx, vote, oldStatus, line.Receipt); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Model(line).Update("fixed", true).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Model(&SettlementImport{}).Where("id = ?", line.ImportID).
+		UpdateColumn("fixed", gorm.Expr("fixed + 1")).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	line.Fixed = true
+	return &vote, nil
+}
+
+// UploadSettlement imports a settlement statement CSV sent as the "file" form field.
+// apply=true fixes paid-but-pending votes and amount mismatches; apply_unpaid=true also fails
+// completed votes the statement shows as unpaid. from and to override the statement period.
+func (vs *VotingSystem) UploadSettlement(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSettlementUpload)
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload the statement CSV as the file field"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file"})
+		return
+	}
+	defer file.Close()
+
+	opts := SettlementOptions{
+		Filename:    header.Filename,
+		Apply:       formBool(c, "apply"),
+		ApplyUnpaid: formBool(c, "apply_unpaid"),
+		Actor:       adminName(c),
+		// Receipts for votes the statement completes go out like any other.
+		StatusChanged: vs.voteStatusChanged,
+	}
+	if opts.ApplyUnpaid && !opts.Apply {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "apply_unpaid requires apply"})
+		return
+	}
+	for name, dst := range map[string]**time.Time{"from": &opts.From, "to": &opts.To} {
+		if value := c.DefaultPostForm(name, c.Query(name)); value != "" {
+			t, err := parseDateParam(value, name == "to")
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			*dst = &t
+		}
+	}
+
+	imp, err := ImportSettlement(vs.DB, file, opts)
+	if err != nil {
+		if errors.Is(err, errInvalidStatement) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Failed to import settlement %s: %s", header.Filename, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import settlement statement"})
+		return
+	}
+
+	log.Printf("%s imported settlement %s: %d rows, %d fixed", opts.Actor, header.Filename, imp.Rows, imp.Fixed)
+	vs.afterTalliesChanged(imp.fixedVotes)
+	c.JSON(http.StatusCreated, imp)
+}
+
+// GetSettlementImports lists settlement imports, newest first, without their lines.
+func (vs *VotingSystem) GetSettlementImports(c *gin.Context) {
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	var imports []SettlementImport
+	if err := vs.DB.Order("id DESC").Limit(limit).Find(&imports).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve settlement imports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, imports)
+}
+
+// GetSettlementImport returns one settlement import with its lines, optionally only those
+// with the given issue.
+func (vs *VotingSystem) GetSettlementImport(c *gin.Context) {
+	var imp SettlementImport
+	if !vs.findRecord(c, &imp, "Settlement import") {
+		return
+	}
+
+	query := vs.DB.Where("import_id = ?", imp.ID).Order("id ASC")
+	if issue := c.Query("issue"); issue != "" {
+		query = query.Where("issue = ?", issue)
+	}
+	if err := query.Find(&imp.Lines).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve settlement lines"})
+		return
+	}
+
+	c.JSON(http.StatusOK, imp)
+}
+
+// formBool reads a boolean from the form body or, failing that, the query string.
+func formBool(c *gin.Context, name string) bool {
+	value, err := strconv.ParseBool(c.DefaultPostForm(name, c.Query(name)))
+	return err == nil && value
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadSettlementCSV(t *testing.T) {
+	statement := strings.Join([]string{
+		"Organisation Name:,FEDCO",
+		"Statement Period:,01/06/2024 - 02/06/2024",
+		"",
+		"Receipt No.,Completion Time,Details,Paid In,Transaction Status,Account No.",
+		"QFT3XYZ12A,2024-06-01 10:15:00,Pay Bill,\"1,000.00\",Completed,FEDCO-1",
+		"QFT3XYZ12B,01/06/2024 11:30,Pay Bill,KES 50,Failed,FEDCO-2",
+		",,,,,",
+		"QFT3XYZ12C,yesterday,Pay Bill,20,,",
+		",2024-06-01 12:00:00,Pay Bill,20,Completed,",
+		"QFT3XYZ12D,2024-06-01 12:00:00,Pay Bill,free,Completed,FEDCO-4",
+	}, "\n")
+
+	lines, err := readSettlementCSV(strings.NewReader(statement))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paidAt := func(value string) *time.Time {
+		t, _ := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+		return &t
+	}
+	want := []SettlementLine{
+		{Row: 5, ExternalID: "FEDCO-1", Receipt: "QFT3XYZ12A", Amount: 1000, StatementStatus: "Completed", PaidAt: paidAt("2024-06-01 10:15:00")},
+		{Row: 6, ExternalID: "FEDCO-2", Receipt: "QFT3XYZ12B", Amount: 50, StatementStatus: "Failed", PaidAt: paidAt("2024-06-01 11:30:00")},
+		{Row: 8, Receipt: "QFT3XYZ12C", Amount: 20},
+		{Row: 9, StatementStatus: "Completed", Issue: SettlementInvalid},
+		{Row: 10, ExternalID: "FEDCO-4", Receipt: "QFT3XYZ12D", StatementStatus: "Completed", Issue: SettlementInvalid},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("read %d lines, want %d: %+v", len(lines), len(want), lines)
+	}
+
+	for i, got := range lines {
+		w := want[i]
+		if got.Row != w.Row || got.ExternalID != w.ExternalID || got.Receipt != w.Receipt ||
+			got.Amount != w.Amount || got.StatementStatus != w.StatementStatus || got.Issue != w.Issue {
+			t.Errorf("line %d = %+v, want %+v", i, got, w)
+		}
+		switch {
+		case w.PaidAt == nil && got.PaidAt != nil:
+			t.Errorf("line %d PaidAt = %s, want none", i, got.PaidAt)
+		case w.PaidAt != nil && (got.PaidAt == nil || !got.PaidAt.Equal(*w.PaidAt)):
+			t.Errorf("line %d PaidAt = %v, want %s", i, got.PaidAt, w.PaidAt)
+		}
+		if w.Issue == SettlementInvalid && got.Detail == "" {
+			t.Errorf("line %d is invalid without a reason", i)
+		}
+	}
+}
+
+func TestReadSettlementCSVHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		wantErr bool
+	}{
+		{"reference only", "Reference,Amount\nFEDCO-1,10\n", false},
+		{"receipt only", "MPESA Receipt Number,Transaction Amount\nQFT3XYZ12A,10\n", false},
+		{"no amount column", "Reference,Receipt\nFEDCO-1,QFT3XYZ12A\n", true},
+		{"no reference or receipt column", "Date,Amount\n2024-06-01,10\n", true},
+		{"empty", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := readSettlementCSV(strings.NewReader(tt.csv))
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidStatement) {
+					t.Errorf("error = %v, want errInvalidStatement", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lines) != 1 || lines[0].Amount != 10 || lines[0].Issue != "" {
+				t.Errorf("lines = %+v, want one valid line for 10", lines)
+			}
+		})
+	}
+}
+
+func TestSettlementPaid(t *testing.T) {
+	for status, want := range map[string]bool{
+		"":          true,
+		"Completed": true,
+		"SUCCESS":   true,
+		"settled":   true,
+		"Failed":    false,
+		"Pending":   false,
+		"Reversed":  false,
+	} {
+		if got := settlementPaid(status); got != want {
+			t.Errorf("settlementPaid(%q) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestSettlementPeriod(t *testing.T) {
+	at := func(value string) *time.Time {
+		t, _ := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+		return &t
+	}
+	lines := []SettlementLine{
+		{PaidAt: at("2024-06-02 08:30:00")},
+		{},
+		{PaidAt: at("2024-06-01 10:15:00")},
+		{PaidAt: at("2024-06-02 23:59:00")},
+	}
+
+	from, to := settlementPeriod(lines, nil, nil)
+	if !from.Equal(*at("2024-06-01 00:00:00")) {
+		t.Errorf("from = %s, want the start of 1 June", from)
+	}
+	if want := at("2024-06-03 00:00:00").Add(-time.Nanosecond); !to.Equal(want) {
+		t.Errorf("to = %s, want the end of 2 June", to)
+	}
+
+	given := at("2024-06-01 12:00:00")
+	if from, to := settlementPeriod(lines, given, given); from != given || to != given {
+		t.Errorf("settlementPeriod() = %s, %s; want the given bounds", from, to)
+	}
+
+	if from, to := settlementPeriod([]SettlementLine{{}}, nil, nil); from != nil || to != nil {
+		t.Errorf("settlementPeriod() without payment times = %v, %v; want no period", from, to)
+	}
+}
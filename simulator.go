@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Outcomes the simulator draws for each payment.
+const (
+	simulateSuccess = "success"
+	simulateFailure = "failure"
+	simulateTimeout = "timeout"
+)
+
+// simulatedFailures are the unsuccessful endings a simulated payment can have, with the
+// report the gateway sends for each.
+var simulatedFailures = []struct{ Status, Report string }{
+	{"FAILED", "The balance is insufficient for the transaction"},
+	{"CANCELLED", "Request cancelled by user"},
+	{"FAILED", "The initiator information is invalid"},
+}
+
+// GatewaySimulator stands in for the mam-laka merchant API in end-to-end tests. It accepts the
+// initiate_mobile_payment payload, answers transaction_status queries and, after Delay plus up
+// to Jitter, posts a signed MpesaCallback to the payment's callbackUrl. Whether the payment
+// succeeds, fails or times out (no callback, status stays PENDING) is drawn at random in
+// proportion to the three ratios.
+type GatewaySimulator struct {
+	Delay        time.Duration
+	Jitter       time.Duration
+	SuccessRatio float64
+	FailureRatio float64
+	TimeoutRatio float64
+	// Secret signs callbacks in X-Signature, as the real gateway is configured to.
+	Secret string
+	// CallbackURL, when set, replaces the callbackUrl sent with each payment.
+	CallbackURL string
+	Client      *http.Client
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	payments map[string]*simulatedPayment
+}
+
+type simulatedPayment struct {
+	Request  simulatedRequest
+	Callback MpesaCallback
+}
+
+// simulatedRequest is the initiate_mobile_payment payload sent by MamlakaProvider.
+type simulatedRequest struct {
+	MerchantID  string `json:"impalaMerchantId"`
+	Currency    string `json:"currency"`
+	Amount      int    `json:"amount"`
+	PayerPhone  string `json:"payerPhone"`
+	Provider    string `json:"mobileMoneySP"`
+	ExternalID  string `json:"externalId"`
+	CallbackURL string `json:"callbackUrl"`
+}
+
+func NewGatewaySimulator(cfg SimulatorConfig, secret string, seed int64) *GatewaySimulator {
+	return &GatewaySimulator{
+		Delay:        cfg.Delay,
+		Jitter:       cfg.Jitter,
+		SuccessRatio: cfg.SuccessRatio,
+		FailureRatio: cfg.FailureRatio,
+		TimeoutRatio: cfg.TimeoutRatio,
+		Secret:       secret,
+		CallbackURL:  cfg.CallbackURL,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		rand:         rand.New(rand.NewSource(seed)),
+		payments:     make(map[string]*simulatedPayment),
+	}
+}
+
+// ServeHTTP dispatches on the action query parameter, like the real merchant API.
+func (s *GatewaySimulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeSimulatorJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "POST only"})
+		return
+	}
+
+	switch action := r.URL.Query().Get("action"); action {
+	case mamlakaInitiateAction:
+		s.initiate(w, r)
+	case mamlakaStatusAction:
+		s.status(w, r)
+	default:
+		writeSimulatorJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown action %q", action)})
+	}
+}
+
+func (s *GatewaySimulator) initiate(w http.ResponseWriter, r *http.Request) {
+	var req simulatedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeSimulatorJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON payload"})
+		return
+	}
+	if s.CallbackURL != "" {
+		req.CallbackURL = s.CallbackURL
+	}
+	if req.ExternalID == "" || req.Amount <= 0 || req.PayerPhone == "" || req.CallbackURL == "" {
+		writeSimulatorJSON(w, http.StatusBadRequest, map[string]string{"error": "externalId, amount, payerPhone and callbackUrl are required"})
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = "KES"
+	}
+
+	s.mu.Lock()
+	if _, exists := s.payments[req.ExternalID]; exists {
+		s.mu.Unlock()
+		writeSimulatorJSON(w, http.StatusConflict, map[string]string{"error": "duplicate externalId"})
+		return
+	}
+	payment := &simulatedPayment{
+		Request: req,
+		Callback: MpesaCallback{
+			TransactionStatus: "PENDING",
+			Currency:          req.Currency,
+			Amount:            strconv.Itoa(req.Amount),
+			ExternalId:        req.ExternalID,
+		},
+	}
+	s.payments[req.ExternalID] = payment
+	outcome := s.draw()
+	delay := s.Delay
+	if s.Jitter > 0 {
+		delay += time.Duration(s.rand.Int63n(int64(s.Jitter)))
+	}
+	s.mu.Unlock()
+
+	log.Printf("Simulator: STK push %s for %d from %s, %s in %s", req.ExternalID, req.Amount, req.PayerPhone, outcome, delay)
+	time.AfterFunc(delay, func() { s.settle(payment, outcome) })
+
+	writeSimulatorJSON(w, http.StatusOK, map[string]interface{}{
+		"status":     "success",
+		"message":    "STK push sent (simulated)",
+		"externalId": req.ExternalID,
+	})
+}
+
+// draw picks an outcome in proportion to the ratios. Call with s.mu held.
+func (s *GatewaySimulator) draw() string {
+	total := s.SuccessRatio + s.FailureRatio + s.TimeoutRatio
+	n := s.rand.Float64() * total
+	switch {
+	case n < s.SuccessRatio:
+		return simulateSuccess
+	case n < s.SuccessRatio+s.FailureRatio:
+		return simulateFailure
+	}
+	return simulateTimeout
+}
+
+func (s *GatewaySimulator) settle(payment *simulatedPayment, outcome string) {
+	if outcome == simulateTimeout {
+		log.Printf("Simulator: %s timed out, no callback sent", payment.Request.ExternalID)
+		return
+	}
+
+	s.mu.Lock()
+	switch outcome {
+	case simulateSuccess:
+		payment.Callback.TransactionStatus = "COMPLETED"
+		payment.Callback.TransactionReport = "Payment processed successfully"
+		payment.Callback.NetAmount = payment.Callback.Amount
+		payment.Callback.SecureId = s.receipt()
+	case simulateFailure:
+		failure := simulatedFailures[s.rand.Intn(len(simulatedFailures))]
+		payment.Callback.TransactionStatus = failure.Status
+		payment.Callback.TransactionReport = failure.Report
+	}
+	callback := payment.Callback
+	s.mu.Unlock()
+
+	body, err := json.Marshal(callback)
+	if err != nil {
+		log.Printf("Simulator: failed to encode callback for %s: %s", callback.ExternalId, err)
+		return
+	}
+
+	// Retry a few times like the real gateway, so a restarting server still gets its callback.
+	for attempt := 1; attempt <= 3; attempt++ {
+		status, err := s.post(payment.Request.CallbackURL, body)
+		if err == nil && status < http.StatusInternalServerError {
+			log.Printf("Simulator: delivered %s callback for %s, HTTP %d", callback.TransactionStatus, callback.ExternalId, status)
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("HTTP %d", status)
+		}
+		log.Printf("Simulator: callback attempt %d for %s failed: %s", attempt, callback.ExternalId, err)
+		time.Sleep(time.Duration(attempt) * 2 * time.Second)
+	}
+}
+
+func (s *GatewaySimulator) post(url string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Secret != "" {
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(SignCallback(s.Secret, body)))
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// receipt makes up an M-Pesa style receipt number. Call with s.mu held.
+func (s *GatewaySimulator) receipt() string {
+	const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, 10)
+	for i := range b {
+		b[i] = letters[s.rand.Intn(len(letters))]
+	}
+	return string(b)
+}
+
+func (s *GatewaySimulator) status(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ExternalID string `json:"externalId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeSimulatorJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON payload"})
+		return
+	}
+
+	s.mu.Lock()
+	payment, ok := s.payments[req.ExternalID]
+	var callback MpesaCallback
+	if ok {
+		callback = payment.Callback
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeSimulatorJSON(w, http.StatusNotFound, map[string]string{"error": "transaction not found"})
+		return
+	}
+	writeSimulatorJSON(w, http.StatusOK, callback)
+}
+
+func writeSimulatorJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// runGatewaySimulator serves the simulator until interrupted. Flags override the simulator
+// section of the config; point gateway.base_url at the simulator's address to use it.
+func runGatewaySimulator(cfg *Config, args []string) error {
+	if cfg.Environment == "production" {
+		return errors.New("the gateway simulator cannot run in production")
+	}
+
+	sim := cfg.Simulator
+	fs := flag.NewFlagSet("simulate-gateway", flag.ExitOnError)
+	fs.StringVar(&sim.Addr, "addr", sim.Addr, "address to listen on")
+	fs.DurationVar(&sim.Delay, "delay", sim.Delay, "time before the callback is sent")
+	fs.DurationVar(&sim.Jitter, "jitter", sim.Jitter, "random extra delay, up to this much")
+	fs.Float64Var(&sim.SuccessRatio, "success", sim.SuccessRatio, "relative share of payments that complete")
+	fs.Float64Var(&sim.FailureRatio, "failure", sim.FailureRatio, "relative share of payments that fail or are cancelled")
+	fs.Float64Var(&sim.TimeoutRatio, "timeout", sim.TimeoutRatio, "relative share of payments that never call back")
+	fs.StringVar(&sim.CallbackURL, "callback-url", sim.CallbackURL, "send every callback here instead of the payment's callbackUrl")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "random seed, for repeatable runs")
+	fs.Parse(args)
+
+	if err := sim.validate(); err != nil {
+		return err
+	}
+
+	server := &http.Server{Addr: sim.Addr, Handler: NewGatewaySimulator(sim, cfg.Callback.Secret.Value(), *seed)}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Gateway simulator listening on %s (delay %s, jitter %s, success/failure/timeout %g/%g/%g)",
+		sim.Addr, sim.Delay, sim.Jitter, sim.SuccessRatio, sim.FailureRatio, sim.TimeoutRatio)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fedco/handlers"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// InboundSMSRequest is a message forwarded by an SMS aggregator such as Africa's Talking.
+type InboundSMSRequest struct {
+	ID   string `form:"id" json:"id"`
+	From string `form:"from" json:"from"`
+	To   string `form:"to" json:"to"`
+	Text string `form:"text" json:"text"`
+}
+
+// smsVoteUsage is the reply to messages that cannot be read as a vote.
+const smsVoteUsage = "To vote, SMS the nominee code followed by the number of votes, e.g. ABC12 5."
+
+// parseSMSVote reads "CODE", "CODE VOTES" or either of them after the keyword VOTE. A missing
+// vote count comes back as zero.
+func parseSMSVote(text string) (string, int, error) {
+	fields := strings.Fields(strings.ToUpper(text))
+	if len(fields) > 1 && fields[0] == "VOTE" {
+		fields = fields[1:]
+	}
+
+	switch len(fields) {
+	case 1:
+		return fields[0], 0, nil
+	case 2:
+		votes, err := strconv.Atoi(fields[1])
+		if err != nil || votes <= 0 {
+			return "", 0, errors.New("invalid vote count")
+		}
+		return fields[0], votes, nil
+	}
+	return "", 0, errors.New("message is not a vote")
+}
+
+// InboundSMS takes keyword votes texted to the short code. The sender is asked to pay through
+// the same STK push as /vote, and told by SMS whether that worked.
+func (vs *VotingSystem) InboundSMS(c *gin.Context) {
+	var req InboundSMSRequest
+	if err := c.ShouldBind(&req); err != nil || req.From == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid SMS payload"})
+		return
+	}
+
+	log.Printf("Inbound SMS %s from %s: %q", req.ID, req.From, req.Text)
+	reply := vs.smsVote(req.From, req.Text)
+
+	if reply == "" {
+		log.Printf("Not replying to SMS %s from unknown sender %s", req.ID, req.From)
+	} else if err := vs.SMS.Send(c.Request.Context(), req.From, reply); err != nil {
+		log.Printf("Failed to send SMS reply to %s: %s", req.From, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "SMS processed"})
+}
+
+// smsVote starts the vote a message asks for and returns the reply to send, or "" when no
+// reply should be sent.
+func (vs *VotingSystem) smsVote(phone, text string) string {
+	code, votes, err := parseSMSVote(text)
+	if err != nil {
+		if !vs.knownVoter(phone) {
+			return ""
+		}
+		return smsVoteUsage
+	}
+
+	var candidate Candidate
+	if err := vs.DB.Where("short_code = ?", code).First(&candidate).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if !vs.knownVoter(phone) {
+				return ""
+			}
+			return fmt.Sprintf("%s is not a nominee code. %s", code, smsVoteUsage)
+		}
+		log.Printf("Failed to look up short code %s: %s", code, err)
+		return "Sorry, we could not take your vote. Please try again later."
+	}
+
+	price, err := vs.PriceForCandidate(candidate.ID)
+	if err != nil {
+		log.Printf("Failed to price SMS vote for candidate %d: %s", candidate.ID, err)
+		return "Sorry, we could not take your vote. Please try again later."
+	}
+	if votes == 0 {
+		// Without a count, buy the smallest purchase allowed.
+		votes = 1
+		if sizes := price.BundleSizes(); len(sizes) > 0 {
+			votes = sizes[0]
+		}
+	}
+	quote, err := price.QuoteVotes(votes)
+	if err != nil {
+		return fmt.Sprintf("Sorry, %s.", err)
+	}
+
+	_, quote, err = vs.StartVote(VoteRequest{
+		VoterPhone:  phone,
+		CandidateID: candidate.ID,
+		Amount:      quote.Amount,
+		Votes:       quote.Votes,
+	})
+	if err != nil {
+		var rejected *voteRejectedError
+		switch {
+		case errors.Is(err, errVotingClosed):
+			return fmt.Sprintf("Voting for %s is not open.", candidate.Name)
+		case errors.Is(err, errRateLimited):
+			return "Sorry, too many vote requests. Please try again later."
+		case errors.As(err, &rejected):
+			return fmt.Sprintf("Sorry, %s.", strings.TrimSuffix(rejected.Error(), "."))
+		}
+		log.Printf("SMS vote for candidate %d from %s failed: %s", candidate.ID, phone, err)
+		return "Sorry, we could not start the payment. Please try again later."
+	}
+
+	return fmt.Sprintf("Check your phone for the M-Pesa prompt to pay KES %d for %d votes for %s.",
+		quote.Amount, quote.Votes, candidate.Name)
+}
+
+// knownVoter reports whether phone has voted before. Messages that are not votes only get a
+// reply when it has, so stray or forged texts cannot make us pay to message any number.
+func (vs *VotingSystem) knownVoter(phone string) bool {
+	normalized, err := handlers.NormalizePhone(phone)
+	if err != nil {
+		return false
+	}
+	var count int64
+	if err := vs.DB.Model(&Voter{}).Where("phone = ?", normalized).Count(&count).Error; err != nil {
+		log.Printf("Failed to look up SMS sender %s: %s", phone, err)
+		return false
+	}
+	return count > 0
+}
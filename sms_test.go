@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestParseSMSVote(t *testing.T) {
+	tests := []struct {
+		text      string
+		wantCode  string
+		wantVotes int
+		wantErr   bool
+	}{
+		{text: "ABC12", wantCode: "ABC12"},
+		{text: "abc12 5", wantCode: "ABC12", wantVotes: 5},
+		{text: "  Vote  abc12\t20 ", wantCode: "ABC12", wantVotes: 20},
+		{text: "VOTE ABC12", wantCode: "ABC12"},
+		{text: "ABC12 0", wantErr: true},
+		{text: "ABC12 -3", wantErr: true},
+		{text: "ABC12 five", wantErr: true},
+		{text: "VOTE ABC12 5 please", wantErr: true},
+		{text: "hello there how are you", wantErr: true},
+		{text: "", wantErr: true},
+		{text: "   ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		code, votes, err := parseSMSVote(tt.text)
+		if (err != nil) != tt.wantErr || code != tt.wantCode || votes != tt.wantVotes {
+			t.Errorf("parseSMSVote(%q) = %q, %d, %v; want %q, %d, error %v",
+				tt.text, code, votes, err, tt.wantCode, tt.wantVotes, tt.wantErr)
+		}
+	}
+}
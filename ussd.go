@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"fedco/handlers"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// USSDRequest is what an aggregator such as Africa's Talking posts for every step of a USSD
+// session. Text holds everything the user has entered so far, joined by "*".
+type USSDRequest struct {
+	SessionID   string `form:"sessionId" json:"sessionId"`
+	ServiceCode string `form:"serviceCode" json:"serviceCode"`
+	PhoneNumber string `form:"phoneNumber" json:"phoneNumber"`
+	Text        string `form:"text" json:"text"`
+}
+
+// Menu levels of a USSD session, in the order they are walked.
+const (
+	ussdCategory = iota
+	ussdPosition
+	ussdCandidate
+	ussdVotes
+	ussdConfirm
+)
+
+const (
+	ussdPageSize = 5
+	ussdNext     = "98"
+	ussdBack     = "0"
+	// ussdNameLength keeps a screen within the 182 characters most networks allow.
+	ussdNameLength = 24
+)
+
+type ussdItem struct {
+	ID   uint
+	Name string
+}
+
+// ussdSession replays the inputs of a USSD session to find the screen to show next. Nothing
+// is stored between requests; the aggregator sends the whole input history every time.
+type ussdSession struct {
+	vs     *VotingSystem
+	phone  string
+	level  int
+	page   int
+	notice string
+
+	category  ussdItem
+	position  ussdItem
+	candidate ussdItem
+	quote     VoteQuote
+}
+
+// USSD answers an aggregator callback with a "CON" screen that waits for input or an "END"
+// screen that closes the session. Voters walk category, position, nominee and vote count, and
+// confirming sends the same STK push as /vote.
+func (vs *VotingSystem) USSD(c *gin.Context) {
+	var req USSDRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.String(http.StatusBadRequest, "END Invalid request")
+		return
+	}
+
+	phone, err := handlers.NormalizePhone(req.PhoneNumber)
+	if err != nil {
+		c.String(http.StatusOK, "END This service needs a Kenyan mobile number.")
+		return
+	}
+
+	session := &ussdSession{vs: vs, phone: phone}
+	screen, err := session.run(req.Text)
+	if err != nil {
+		log.Printf("USSD session %s for %s failed: %s", req.SessionID, phone, err)
+		screen = "END Sorry, something went wrong. Please try again later."
+	}
+
+	c.String(http.StatusOK, screen)
+}
+
+// run applies every input in text and returns the resulting screen.
+func (s *ussdSession) run(text string) (string, error) {
+	var inputs []string
+	if text != "" {
+		inputs = strings.Split(text, "*")
+	}
+
+	for _, input := range inputs {
+		input = strings.TrimSpace(input)
+		s.notice = ""
+
+		if input == ussdBack && s.level > ussdCategory {
+			s.level--
+			s.page = 0
+			continue
+		}
+
+		switch s.level {
+		case ussdCategory, ussdPosition, ussdCandidate:
+			items, err := s.items()
+			if err != nil {
+				return "", err
+			}
+			if input == ussdNext && (s.page+1)*ussdPageSize < len(items) {
+				s.page++
+				continue
+			}
+			n, err := strconv.Atoi(input)
+			index := s.page*ussdPageSize + n - 1
+			if err != nil || n < 1 || n > ussdPageSize || index >= len(items) {
+				s.notice = "Invalid choice."
+				continue
+			}
+			s.choose(items[index])
+
+		case ussdVotes:
+			votes, err := strconv.Atoi(input)
+			if err != nil {
+				s.notice = "Enter a number of votes."
+				continue
+			}
+			price, err := s.vs.PriceForCandidate(s.candidate.ID)
+			if err != nil {
+				return "", err
+			}
+			quote, err := price.QuoteVotes(votes)
+			if err != nil {
+				s.notice = err.Error() + "."
+				continue
+			}
+			s.quote = quote
+			s.level = ussdConfirm
+
+		case ussdConfirm:
+			switch input {
+			case "1":
+				return s.submit(), nil
+			case "2":
+				return "END Vote cancelled.", nil
+			}
+			s.notice = "Invalid choice."
+		}
+	}
+
+	return s.screen()
+}
+
+func (s *ussdSession) choose(item ussdItem) {
+	switch s.level {
+	case ussdCategory:
+		s.category = item
+	case ussdPosition:
+		s.position = item
+	case ussdCandidate:
+		s.candidate = item
+	}
+	s.level++
+	s.page = 0
+}
+
+// items lists the choices at the current menu level, in a stable order.
+func (s *ussdSession) items() ([]ussdItem, error) {
+	var items []ussdItem
+	switch s.level {
+	case ussdCategory:
+		var categories []Category
+		if err := s.vs.DB.Order("id ASC").Find(&categories).Error; err != nil {
+			return nil, fmt.Errorf("failed to load categories: %w", err)
+		}
+		var elections []Election
+		if err := s.vs.DB.Where("status = ?", ElectionOpen).Find(&elections).Error; err != nil {
+			return nil, fmt.Errorf("failed to load elections: %w", err)
+		}
+		open := make(map[uint]bool)
+		for _, election := range elections {
+			open[election.ID] = election.AcceptingVotes(time.Now())
+		}
+		for _, category := range categories {
+			if category.ElectionID == nil || open[*category.ElectionID] {
+				items = append(items, ussdItem{category.ID, category.Name})
+			}
+		}
+	case ussdPosition:
+		if err := s.vs.DB.Model(&Position{}).Select("id, name").
+			Where("category_id = ?", s.category.ID).Order("id ASC").Scan(&items).Error; err != nil {
+			return nil, fmt.Errorf("failed to load positions: %w", err)
+		}
+	case ussdCandidate:
+		if err := s.vs.DB.Model(&Candidate{}).Select("id, name").
+			Where("position_id = ?", s.position.ID).Order("id ASC").Scan(&items).Error; err != nil {
+			return nil, fmt.Errorf("failed to load candidates: %w", err)
+		}
+	}
+	return items, nil
+}
+
+// screen renders the current menu level.
+func (s *ussdSession) screen() (string, error) {
+	var b strings.Builder
+	b.WriteString("CON ")
+	if s.notice != "" {
+		b.WriteString(s.notice + "\n")
+	}
+
+	switch s.level {
+	case ussdCategory, ussdPosition, ussdCandidate:
+		items, err := s.items()
+		if err != nil {
+			return "", err
+		}
+		if s.level == ussdCategory && len(items) == 0 {
+			return "END There is no voting open right now.", nil
+		}
+
+		switch s.level {
+		case ussdCategory:
+			b.WriteString("Choose a category")
+		case ussdPosition:
+			fmt.Fprintf(&b, "%s: choose a position", ussdName(s.category.Name))
+		case ussdCandidate:
+			fmt.Fprintf(&b, "%s: choose a nominee", ussdName(s.position.Name))
+		}
+		if len(items) == 0 {
+			b.WriteString("\nNothing to choose here yet.")
+		}
+
+		start := s.page * ussdPageSize
+		for i := start; i < len(items) && i < start+ussdPageSize; i++ {
+			fmt.Fprintf(&b, "\n%d. %s", i-start+1, ussdName(items[i].Name))
+		}
+		if start+ussdPageSize < len(items) {
+			b.WriteString("\n" + ussdNext + ". More")
+		}
+
+	case ussdVotes:
+		price, err := s.vs.PriceForCandidate(s.candidate.ID)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "Votes for %s (KES %d each)", ussdName(s.candidate.Name), price.PricePerVote)
+		if sizes := price.BundleSizes(); len(sizes) > 0 {
+			fmt.Fprintf(&b, "\nChoose from: %s", price.Bundles)
+		}
+		b.WriteString("\nEnter number of votes:")
+
+	case ussdConfirm:
+		fmt.Fprintf(&b, "%d votes for %s\nPay KES %d with M-Pesa?\n1. Pay\n2. Cancel",
+			s.quote.Votes, ussdName(s.candidate.Name), s.quote.Amount)
+	}
+
+	if s.level > ussdCategory {
+		b.WriteString("\n" + ussdBack + ". Back")
+	}
+	return b.String(), nil
+}
+
+// submit starts the vote through the same path as /vote and ends the session.
+func (s *ussdSession) submit() string {
+	_, quote, err := s.vs.StartVote(VoteRequest{
+		VoterPhone:  s.phone,
+		CandidateID: s.candidate.ID,
+		Amount:      s.quote.Amount,
+		Votes:       s.quote.Votes,
+	})
+	if err != nil {
+		var rejected *voteRejectedError
+		switch {
+		case errors.Is(err, errVotingClosed):
+			return "END Voting is not open for this election."
+		case errors.Is(err, errRateLimited):
+			return "END Too many vote requests. Please try again later."
+		case errors.As(err, &rejected):
+			return "END " + rejected.Error()
+		}
+		log.Printf("USSD vote for candidate %d from %s failed: %s", s.candidate.ID, s.phone, err)
+		return "END Sorry, we could not start the payment. Please try again."
+	}
+
+	return fmt.Sprintf("END Check your phone for the M-Pesa prompt to pay KES %d for %d votes for %s.",
+		quote.Amount, quote.Votes, ussdName(s.candidate.Name))
+}
+
+func ussdName(name string) string {
+	runes := []rune(strings.TrimSpace(name))
+	if len(runes) > ussdNameLength {
+		return string(runes[:ussdNameLength-3]) + "..."
+	}
+	return string(runes)
+}
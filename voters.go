@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fedco/handlers"
+	"fmt"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// VoterMergeReport summarises what MergeDuplicateVoters did (or would do, on a dry run).
+type VoterMergeReport struct {
+	Voters          int `json:"voters"`
+	DuplicateGroups int `json:"duplicate_groups"`
+	VotersMerged    int `json:"voters_merged"`
+	VotesRepointed  int `json:"votes_repointed"`
+	PhonesRewritten int `json:"phones_rewritten"`
+	InvalidPhones   int `json:"invalid_phones"`
+}
+
+// GetOrCreateVoter returns the voter with the given phone number, creating one if none exists.
+func (vs *VotingSystem) GetOrCreateVoter(name, phone string) (*Voter, error) {
+	normalized, err := handlers.NormalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	var voter Voter
+	err = vs.DB.Unscoped().Where("phone = ?", normalized).First(&voter).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		voter = Voter{Name: name, Phone: normalized}
+		if err := vs.DB.Create(&voter).Error; err != nil {
+			// Another request may have created the same voter in the meantime.
+			if findErr := vs.DB.Where("phone = ?", normalized).First(&voter).Error; findErr != nil {
+				return nil, errors.New("failed to create voter")
+			}
+		}
+		return &voter, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up voter: %w", err)
+	}
+
+	if voter.DeletedAt.Valid {
+		if err := vs.DB.Unscoped().Model(&voter).Update("deleted_at", nil).Error; err != nil {
+			return nil, fmt.Errorf("failed to restore voter: %w", err)
+		}
+	}
+	if voter.Name == "" && name != "" {
+		vs.DB.Model(&voter).Update("name", name)
+	}
+
+	return &voter, nil
+}
+
+// MergeDuplicateVoters normalizes every voter phone and folds voters sharing a number into the
+// oldest one, re-pointing their votes. It must run before the unique phone index can be created.
+func MergeDuplicateVoters(db *gorm.DB, dryRun bool) (*VoterMergeReport, error) {
+	var voters []Voter
+	if err := db.Unscoped().Order("id ASC").Find(&voters).Error; err != nil {
+		return nil, fmt.Errorf("failed to load voters: %w", err)
+	}
+
+	report := &VoterMergeReport{Voters: len(voters)}
+	groups := make(map[string][]Voter)
+	var order []string
+	for _, voter := range voters {
+		key, err := handlers.NormalizePhone(voter.Phone)
+		if err != nil {
+			report.InvalidPhones++
+			key = strings.TrimSpace(voter.Phone)
+		}
+		if _, seen := groups[key]; !seen {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], voter)
+	}
+
+	for _, phone := range order {
+		group := groups[phone]
+		keeper, duplicates := group[0], group[1:]
+
+		var duplicateIDs []uint
+		for _, duplicate := range duplicates {
+			duplicateIDs = append(duplicateIDs, duplicate.ID)
+			if keeper.Name == "" {
+				keeper.Name = duplicate.Name
+			}
+		}
+
+		if len(duplicates) > 0 {
+			report.DuplicateGroups++
+			report.VotersMerged += len(duplicates)
+		}
+		rewrite := keeper.Phone != phone
+
+		if dryRun {
+			if len(duplicateIDs) > 0 {
+				var votes int64
+				db.Unscoped().Model(&Vote{}).Where("voter_id IN ?", duplicateIDs).Count(&votes)
+				report.VotesRepointed += int(votes)
+			}
+			if rewrite {
+				report.PhonesRewritten++
+			}
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if len(duplicateIDs) > 0 {
+				result := tx.Unscoped().Model(&Vote{}).Where("voter_id IN ?", duplicateIDs).Update("voter_id", keeper.ID)
+				if result.Error != nil {
+					return result.Error
+				}
+				report.VotesRepointed += int(result.RowsAffected)
+
+				if err := tx.Unscoped().Delete(&Voter{}, duplicateIDs).Error; err != nil {
+					return err
+				}
+			}
+			if rewrite || len(duplicateIDs) > 0 {
+				if err := tx.Unscoped().Model(&Voter{}).Where("id = ?", keeper.ID).
+					Updates(map[string]interface{}{"phone": phone, "name": keeper.Name}).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return report, fmt.Errorf("failed to merge voters with phone %s: %w", phone, err)
+		}
+		if rewrite {
+			report.PhonesRewritten++
+		}
+		if len(duplicateIDs) > 0 {
+			log.Printf("Merged voters %v into %d (%s)", duplicateIDs, keeper.ID, phone)
+		}
+	}
+
+	return report, nil
+}
+
+// votersNeedMerge reports whether MergeDuplicateVoters has work to do: a voter phone not stored
+// in normalized form, such as a legacy 07... number, or one shared with another voter once
+// normalized. Either lets GetOrCreateVoter miss an existing voter and create a duplicate, and
+// duplicates stop the unique index on voters.phone from being created.
+func votersNeedMerge(db *gorm.DB) (bool, error) {
+	if !db.Migrator().HasTable(&Voter{}) {
+		return false, nil
+	}
+
+	var phones []string
+	if err := db.Unscoped().Model(&Voter{}).Pluck("phone", &phones).Error; err != nil {
+		return false, err
+	}
+
+	seen := make(map[string]bool, len(phones))
+	for _, phone := range phones {
+		// Keyed the same way as MergeDuplicateVoters groups voters.
+		key, err := handlers.NormalizePhone(phone)
+		if err != nil {
+			key = strings.TrimSpace(phone)
+		}
+		if key != phone || seen[key] {
+			return true, nil
+		}
+		seen[key] = true
+	}
+	return false, nil
+}
@@ -0,0 +1,589 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fedco/models"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Events partners can subscribe to.
+const (
+	WebhookVoteCompleted = "vote.completed"
+	// WebhookVoteReversed is sent when a completed vote stops counting, because the gateway
+	// reversed the payment or a settlement statement showed it unpaid. data.status says which.
+	WebhookVoteReversed = "vote.reversed"
+)
+
+var webhookEvents = []string{WebhookVoteCompleted, WebhookVoteReversed}
+
+// Webhook delivery statuses.
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	// WebhookDead is a dead letter: every attempt failed and the dispatcher gave up.
+	WebhookDead = "dead"
+)
+
+// WebhookSubscription is a partner endpoint that receives vote events, signed with Secret.
+type WebhookSubscription struct {
+	gorm.Model
+	Name string `json:"name"`
+	URL  string `gorm:"type:text" json:"url"`
+	// Secret signs deliveries in X-Fedco-Signature. It is only shown when created or rotated.
+	Secret string `json:"-"`
+	// Events is a comma-separated list of event names; empty means every event.
+	Events string `json:"events"`
+	// ElectionID limits the subscription to one election's votes.
+	ElectionID *uint `gorm:"index" json:"election_id"`
+	Active     bool  `json:"active"`
+}
+
+// Wants reports whether the subscription takes the event for a vote in the given election.
+func (s *WebhookSubscription) Wants(event string, electionID *uint) bool {
+	if s.ElectionID != nil && (electionID == nil || *electionID != *s.ElectionID) {
+		return false
+	}
+	if strings.TrimSpace(s.Events) == "" {
+		return true
+	}
+	for _, name := range strings.Split(s.Events, ",") {
+		if strings.TrimSpace(name) == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one event for one subscription in the outbox. Rows are written in the
+// transaction that changed the vote and sent later by the WebhookDispatcher.
+type WebhookDelivery struct {
+	gorm.Model
+	SubscriptionID uint   `gorm:"uniqueIndex:idx_webhook_delivery_event" json:"subscription_id"`
+	EventID        string `gorm:"uniqueIndex:idx_webhook_delivery_event;size:150" json:"event_id"`
+	Event          string `gorm:"index;size:64" json:"event"`
+	Payload        string `gorm:"type:text" json:"payload"`
+	Status         string `gorm:"index;size:16" json:"status"`
+	Attempts       int    `json:"attempts"`
+	// LastStatusCode is the HTTP status of the last attempt, zero when no response came back.
+	LastStatusCode int        `json:"last_status_code"`
+	LastError      string     `gorm:"type:text" json:"last_error,omitempty"`
+	NextAttemptAt  time.Time  `gorm:"index" json:"next_attempt_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+}
+
+// WebhookEvent is the body posted to subscribers. ID is the same for every subscriber and
+// every retry, so receivers can drop duplicates, and differs each time a vote changes, so a vote
+// completed again after a reversal is reported again.
+type WebhookEvent struct {
+	ID         string        `json:"id"`
+	Event      string        `json:"event"`
+	OccurredAt time.Time     `json:"occurred_at"`
+	Data       VoteEventData `json:"data"`
+}
+
+// VoteEventData describes the vote and the candidate's running total after it.
+type VoteEventData struct {
+	ExternalID    string `json:"external_id"`
+	Status        string `json:"status"`
+	Votes         int    `json:"votes"`
+	Amount        int    `json:"amount"`
+	CandidateID   uint   `json:"candidate_id"`
+	CandidateName string `json:"candidate_name"`
+	PositionID    uint   `json:"position_id"`
+	PositionName  string `json:"position_name"`
+	CategoryID    uint   `json:"category_id"`
+	CategoryName  string `json:"category_name"`
+	ElectionID    *uint  `json:"election_id"`
+	// CandidateTotalVotes counts every completed vote for the candidate, including this one.
+	CandidateTotalVotes int `json:"candidate_total_votes"`
+}
+
+// queueVoteWebhooks writes the event for a vote whose status changed from oldStatus into the
+// outbox of every subscription that wants it. It must run inside the transaction that saved
+// the vote, so the event is queued if and only if the change is committed.
+func queueVoteWebhooks(tx *gorm.DB, vote Vote, oldStatus string) error {
+	var event string
+	switch {
+	case vote.Status == models.VoteCompleted && oldStatus != models.VoteCompleted:
+		event = WebhookVoteCompleted
+	case vote.Status != models.VoteCompleted && oldStatus == models.VoteCompleted:
+		event = WebhookVoteReversed
+	default:
+		return nil
+	}
+
+	var subscriptions []WebhookSubscription
+	if err := tx.Where("active = ?", true).Find(&subscriptions).Error; err != nil {
+		return fmt.Errorf("failed to load webhook subscriptions: %w", err)
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	data := VoteEventData{
+		ExternalID:  vote.ExternalID,
+		Status:      vote.Status,
+		Votes:       vote.VoteCount,
+		Amount:      vote.Amount,
+		CandidateID: vote.CandidateID,
+	}
+	if vote.PricePerVote == 0 {
+		data.Votes = vote.Amount / defaultPricePerVote
+	}
+	var candidate struct {
+		CandidateName string
+		PositionID    uint
+		PositionName  string
+		CategoryID    uint
+		CategoryName  string
+		ElectionID    *uint
+	}
+	if err := tx.Table("candidates").
+		Select("candidates.name AS candidate_name, positions.id AS position_id, positions.name AS position_name, "+
+			"categories.id AS category_id, categories.name AS category_name, categories.election_id").
+		Joins("JOIN positions ON positions.id = candidates.position_id").
+		Joins("JOIN categories ON categories.id = positions.category_id").
+		Where("candidates.id = ?", vote.CandidateID).
+		Scan(&candidate).Error; err != nil {
+		return fmt.Errorf("failed to load candidate for webhook: %w", err)
+	}
+	data.CandidateName = candidate.CandidateName
+	data.PositionID, data.PositionName = candidate.PositionID, candidate.PositionName
+	data.CategoryID, data.CategoryName = candidate.CategoryID, candidate.CategoryName
+	data.ElectionID = candidate.ElectionID
+	if err := tx.Model(&Vote{}).
+		Select("COALESCE(SUM("+legacyVoteCountExpr+"), 0)").
+		Where("votes.candidate_id = ? AND votes.status = ?", vote.CandidateID, models.VoteCompleted).
+		Scan(&data.CandidateTotalVotes).Error; err != nil {
+		return fmt.Errorf("failed to total candidate votes for webhook: %w", err)
+	}
+
+	now := time.Now()
+	payload := WebhookEvent{
+		ID:         fmt.Sprintf("%s:%s:%d", event, vote.ExternalID, now.UnixNano()),
+		Event:      event,
+		OccurredAt: now.UTC(),
+		Data:       data,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var deliveries []WebhookDelivery
+	for _, subscription := range subscriptions {
+		if !subscription.Wants(event, data.ElectionID) {
+			continue
+		}
+		deliveries = append(deliveries, WebhookDelivery{
+			SubscriptionID: subscription.ID,
+			EventID:        payload.ID,
+			Event:          event,
+			Payload:        string(body),
+			Status:         WebhookPending,
+			NextAttemptAt:  now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error
+}
+
+// webhookLease is how long a claimed delivery is hidden from other dispatchers while it is sent.
+const webhookLease = 5 * time.Minute
+
+// WebhookDispatcher posts queued webhook deliveries, retrying failures with exponential backoff
+// and turning them into dead letters after MaxAttempts.
+type WebhookDispatcher struct {
+	DB     *gorm.DB
+	Client *http.Client
+
+	Interval    time.Duration
+	MaxAttempts int
+	BatchSize   int
+}
+
+func NewWebhookDispatcher(db *gorm.DB) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		DB:          db,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		Interval:    5 * time.Second,
+		MaxAttempts: 10,
+		BatchSize:   100,
+	}
+}
+
+// Run dispatches due deliveries every Interval until ctx is cancelled.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.RunOnce(ctx); err != nil {
+			log.Printf("Webhook dispatch failed: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce tries one batch of due deliveries and returns how many were delivered.
+func (d *WebhookDispatcher) RunOnce(ctx context.Context) (int, error) {
+	var due []WebhookDelivery
+	if err := d.DB.Where("status = ? AND next_attempt_at <= ?", WebhookPending, time.Now()).
+		Order("id ASC").
+		Limit(d.BatchSize).
+		Find(&due).Error; err != nil {
+		return 0, fmt.Errorf("failed to load webhook deliveries: %w", err)
+	}
+
+	delivered := 0
+	for _, delivery := range due {
+		if ctx.Err() != nil {
+			return delivered, ctx.Err()
+		}
+		if d.deliver(ctx, delivery) {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+// deliver claims and posts one delivery and records the outcome.
+func (d *WebhookDispatcher) deliver(ctx context.Context, delivery WebhookDelivery) bool {
+	claim := d.DB.Model(&WebhookDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at = ?", delivery.ID, WebhookPending, delivery.NextAttemptAt).
+		Update("next_attempt_at", time.Now().Add(webhookLease))
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		return false
+	}
+
+	attempts := delivery.Attempts + 1
+	updates := map[string]interface{}{"attempts": attempts}
+
+	// Nothing to retry against once the subscription is gone or disabled.
+	var subscription WebhookSubscription
+	if err := d.DB.First(&subscription, delivery.SubscriptionID).Error; err != nil || !subscription.Active {
+		updates["status"] = WebhookDead
+		updates["last_error"] = "subscription deleted or disabled"
+		d.record(delivery, updates)
+		return false
+	}
+
+	status, err := d.post(ctx, subscription, delivery)
+	updates["last_status_code"] = status
+	if err == nil {
+		updates["status"] = WebhookDelivered
+		updates["delivered_at"] = time.Now()
+		updates["last_error"] = ""
+	} else {
+		updates["last_error"] = err.Error()
+		if attempts >= d.MaxAttempts {
+			updates["status"] = WebhookDead
+			log.Printf("Webhook delivery %d to %s is dead after %d attempts: %s", delivery.ID, subscription.Name, attempts, err)
+		} else {
+			updates["next_attempt_at"] = time.Now().Add(retryBackoff(attempts))
+			log.Printf("Webhook delivery %d to %s failed, attempt %d: %s", delivery.ID, subscription.Name, attempts, err)
+		}
+	}
+	d.record(delivery, updates)
+	return err == nil
+}
+
+func (d *WebhookDispatcher) record(delivery WebhookDelivery, updates map[string]interface{}) {
+	if err := d.DB.Model(&WebhookDelivery{}).Where("id = ?", delivery.ID).Updates(updates).Error; err != nil {
+		log.Printf("Failed to record webhook delivery %d: %s", delivery.ID, err)
+	}
+}
+
+// post sends the delivery, signed as hex HMAC-SHA256 of the body in X-Fedco-Signature. Any
+// 2xx response counts as delivered.
+func (d *WebhookDispatcher) post(ctx context.Context, subscription WebhookSubscription, delivery WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Fedco-Event", delivery.Event)
+	req.Header.Set("X-Fedco-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Fedco-Signature", "sha256="+hex.EncodeToString(SignCallback(subscription.Secret, body)))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return resp.StatusCode, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	return resp.StatusCode, nil
+}
+
+type WebhookSubscriptionRequest struct {
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	Events     []string `json:"events"`
+	ElectionID *uint    `json:"election_id"`
+	Active     *bool    `json:"active"`
+	// Secret is generated when left empty on create.
+	Secret string `json:"secret"`
+	// RotateSecret replaces the secret with a generated one on update.
+	RotateSecret bool `json:"rotate_secret"`
+}
+
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http or https URL")
+	}
+	return nil
+}
+
+func webhookEventList(events []string) (string, error) {
+	var names []string
+	for _, event := range events {
+		event = strings.TrimSpace(event)
+		known := false
+		for _, name := range webhookEvents {
+			if event == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return "", fmt.Errorf("unknown event %q, expected one of %s", event, strings.Join(webhookEvents, ", "))
+		}
+		names = append(names, event)
+	}
+	return strings.Join(names, ","), nil
+}
+
+func newWebhookSecret() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+func (vs *VotingSystem) GetWebhookSubscriptions(c *gin.Context) {
+	var subscriptions []WebhookSubscription
+	if err := vs.DB.Order("id ASC").Find(&subscriptions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhook subscriptions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, subscriptions)
+}
+
+// CreateWebhookSubscription registers a partner endpoint. The response is the only time the
+// secret is shown.
+func (vs *VotingSystem) CreateWebhookSubscription(c *gin.Context) {
+	var req WebhookSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.URL = strings.TrimSpace(req.URL)
+	if err := validateWebhookURL(req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	events, err := webhookEventList(req.Events)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ElectionID != nil {
+		var election Election
+		if err := vs.DB.First(&election, *req.ElectionID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Election not found"})
+			return
+		}
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		if secret, err = newWebhookSecret(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate webhook secret"})
+			return
+		}
+	}
+
+	subscription := WebhookSubscription{
+		Name:       strings.TrimSpace(req.Name),
+		URL:        req.URL,
+		Secret:     secret,
+		Events:     events,
+		ElectionID: req.ElectionID,
+		Active:     req.Active == nil || *req.Active,
+	}
+	if err := vs.DB.Create(&subscription).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook subscription"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "Webhook subscription created successfully",
+		"subscription": subscription,
+		"secret":       secret,
+	})
+}
+
+// UpdateWebhookSubscription changes the fields present in the request.
+func (vs *VotingSystem) UpdateWebhookSubscription(c *gin.Context) {
+	var subscription WebhookSubscription
+	if !vs.findRecord(c, &subscription, "Webhook subscription") {
+		return
+	}
+
+	var req WebhookSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if name := strings.TrimSpace(req.Name); name != "" {
+		subscription.Name = name
+	}
+	if req.URL != "" {
+		req.URL = strings.TrimSpace(req.URL)
+		if err := validateWebhookURL(req.URL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		subscription.URL = req.URL
+	}
+	if req.Events != nil {
+		events, err := webhookEventList(req.Events)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		subscription.Events = events
+	}
+	if req.ElectionID != nil {
+		subscription.ElectionID = req.ElectionID
+	}
+	if req.Active != nil {
+		subscription.Active = *req.Active
+	}
+
+	var secret string
+	if req.RotateSecret || req.Secret != "" {
+		secret = req.Secret
+		if secret == "" {
+			var err error
+			if secret, err = newWebhookSecret(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate webhook secret"})
+				return
+			}
+		}
+		subscription.Secret = secret
+	}
+
+	if err := vs.DB.Model(&subscription).Updates(map[string]interface{}{
+		"name":        subscription.Name,
+		"url":         subscription.URL,
+		"events":      subscription.Events,
+		"election_id": subscription.ElectionID,
+		"active":      subscription.Active,
+		"secret":      subscription.Secret,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook subscription"})
+		return
+	}
+
+	response := gin.H{"message": "Webhook subscription updated successfully", "subscription": subscription}
+	if secret != "" {
+		response["secret"] = secret
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (vs *VotingSystem) DeleteWebhookSubscription(c *gin.Context) {
+	var subscription WebhookSubscription
+	if !vs.findRecord(c, &subscription, "Webhook subscription") {
+		return
+	}
+
+	if err := vs.DB.Delete(&subscription).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook subscription"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook subscription deleted successfully"})
+}
+
+// GetWebhookDeliveries lists the outbox, newest first. status=dead lists the dead letters.
+func (vs *VotingSystem) GetWebhookDeliveries(c *gin.Context) {
+	query := vs.DB.Model(&WebhookDelivery{}).Order("id DESC")
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if subscriptionID := c.Query("subscription_id"); subscriptionID != "" {
+		query = query.Where("subscription_id = ?", subscriptionID)
+	}
+
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	var deliveries []WebhookDelivery
+	if err := query.Limit(limit).Find(&deliveries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhook deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// ReplayWebhookDelivery puts a dead letter back in the queue with a fresh set of attempts.
+func (vs *VotingSystem) ReplayWebhookDelivery(c *gin.Context) {
+	var delivery WebhookDelivery
+	if !vs.findRecord(c, &delivery, "Webhook delivery") {
+		return
+	}
+	if delivery.Status != WebhookDead {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Delivery is %s, only dead deliveries can be replayed", delivery.Status)})
+		return
+	}
+
+	if err := vs.DB.Model(&delivery).Updates(map[string]interface{}{
+		"status":          WebhookPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay webhook delivery"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook delivery queued for replay"})
+}